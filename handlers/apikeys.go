@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"chat-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// API key scopes: read allows safe (GET) requests, write allows everything
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+
+	apiKeyPrefix = "sk-chat-"
+	apiKeyIDKey  = "api_key_id"
+)
+
+// CreateAPIKey issues a new API key for the authenticated user
+func (h *AuthHandler) CreateAPIKey(c *gin.Context) {
+	if !h.requireSession(c) {
+		return
+	}
+
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{scopeRead, scopeWrite}
+	}
+
+	secret, err := newToken()
+	if err != nil {
+		log.Printf("Failed to generate API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+	key := apiKeyPrefix + secret
+
+	apiKey := models.APIKey{
+		ID:        uuid.New(),
+		Name:      req.Name,
+		Prefix:    key[:len(apiKeyPrefix)+6],
+		Scopes:    req.Scopes,
+		CreatedAt: time.Now(),
+	}
+	_, err = h.db.ExecContext(c.Request.Context(),
+		`INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		apiKey.ID, currentUserID(c), apiKey.Name, apiKey.Prefix, hashToken(key), pq.Array(apiKey.Scopes), apiKey.CreatedAt)
+	if err != nil {
+		log.Printf("Database error creating API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.CreateAPIKeyResponse{APIKey: apiKey, Key: key})
+}
+
+// ListAPIKeys lists the authenticated user's API keys, including revoked ones
+func (h *AuthHandler) ListAPIKeys(c *gin.Context) {
+	if !h.requireSession(c) {
+		return
+	}
+
+	rows, err := h.db.QueryContext(c.Request.Context(),
+		`SELECT id, name, prefix, scopes, created_at, last_used_at, revoked_at
+		 FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC`,
+		currentUserID(c))
+	if err != nil {
+		log.Printf("Database error listing API keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API keys"})
+		return
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		var k models.APIKey
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan API key"})
+			return
+		}
+		keys = append(keys, k)
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// RevokeAPIKey revokes one of the authenticated user's API keys
+func (h *AuthHandler) RevokeAPIKey(c *gin.Context) {
+	if !h.requireSession(c) {
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	result, err := h.db.ExecContext(c.Request.Context(),
+		"UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		id, currentUserID(c))
+	if err != nil {
+		log.Printf("Database error revoking API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
+
+// authenticateAPIKey resolves an API key to its owner, recording when it was last used.
+// It aborts the request and returns false if the key is unknown, revoked, or lacks the
+// scope the request method needs.
+func (h *AuthHandler) authenticateAPIKey(c *gin.Context, key string) bool {
+	var keyID, userID uuid.UUID
+	var scopes []string
+	err := h.db.QueryRowContext(c.Request.Context(),
+		`UPDATE api_keys SET last_used_at = now()
+		 WHERE key_hash = $1 AND revoked_at IS NULL
+		 RETURNING id, user_id, scopes`,
+		hashToken(key)).Scan(&keyID, &userID, pq.Array(&scopes))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or revoked API key"})
+		return false
+	}
+
+	if !scopeAllows(scopes, c.Request.Method) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key is read-only"})
+		return false
+	}
+
+	c.Set(userIDKey, userID)
+	c.Set(apiKeyIDKey, keyID)
+	return true
+}
+
+// requireSession rejects requests authenticated with an API key; keys cannot manage keys
+func (h *AuthHandler) requireSession(c *gin.Context) bool {
+	if _, ok := c.Get(apiKeyIDKey); ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot manage API keys; log in instead"})
+		return false
+	}
+	return true
+}
+
+// scopeAllows reports whether scopes permit a request with the given HTTP method
+func scopeAllows(scopes []string, method string) bool {
+	for _, scope := range scopes {
+		if scope == scopeWrite {
+			return true
+		}
+		if scope == scopeRead && (method == http.MethodGet || method == http.MethodHead) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAPIKey reports whether a bearer token is an API key rather than a session token
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
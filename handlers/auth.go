@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"chat-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Keys under which RequireAuth stores the caller's identity in the gin context
+const (
+	userIDKey    = "user_id"
+	tokenHashKey = "token_hash"
+)
+
+// AuthHandler handles account registration, login and request authentication.
+// Accounts and sessions are written directly rather than through DBOS workflows
+// so that passwords and tokens never end up in recorded workflow inputs.
+type AuthHandler struct {
+	db         *sql.DB
+	sessionTTL time.Duration
+}
+
+// NewAuthHandler creates a new auth handler
+func NewAuthHandler(db *sql.DB, sessionTTL time.Duration) *AuthHandler {
+	return &AuthHandler{
+		db:         db,
+		sessionTTL: sessionTTL,
+	}
+}
+
+// Register creates a new account and logs it in
+func (h *AuthHandler) Register(c *gin.Context) {
+	var req models.CredentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("Failed to hash password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register"})
+		return
+	}
+
+	user := models.User{
+		ID:        uuid.New(),
+		Email:     normalizeEmail(req.Email),
+		CreatedAt: time.Now(),
+	}
+	_, err = h.db.ExecContext(c.Request.Context(),
+		"INSERT INTO users (id, email, password_hash, created_at) VALUES ($1, $2, $3, $4)",
+		user.ID, user.Email, string(hash), user.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+			return
+		}
+		log.Printf("Database error registering user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register"})
+		return
+	}
+
+	resp, err := h.createSession(c.Request.Context(), user)
+	if err != nil {
+		log.Printf("Failed to create session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
+
+// Login verifies credentials and issues a bearer token
+func (h *AuthHandler) Login(c *gin.Context) {
+	var req models.CredentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	var user models.User
+	var hash string
+	err := h.db.QueryRowContext(c.Request.Context(),
+		"SELECT id, email, created_at, password_hash FROM users WHERE email = $1", normalizeEmail(req.Email)).
+		Scan(&user.ID, &user.Email, &user.CreatedAt, &hash)
+	if err != nil || bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		return
+	}
+
+	resp, err := h.createSession(c.Request.Context(), user)
+	if err != nil {
+		log.Printf("Failed to create session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// Logout revokes the bearer token used for the request. A request made with an API
+// key has no session to end and is rejected; keys are revoked through DELETE /api/keys/:id.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	result, err := h.db.ExecContext(c.Request.Context(),
+		"DELETE FROM sessions WHERE token_hash = $1", c.GetString(tokenHashKey))
+	if err != nil {
+		log.Printf("Database error logging out: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No session to log out of; revoke API keys with DELETE /api/keys/:id"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// Me returns the authenticated user
+func (h *AuthHandler) Me(c *gin.Context) {
+	var user models.User
+	err := h.db.QueryRowContext(c.Request.Context(),
+		"SELECT id, email, created_at FROM users WHERE id = $1", currentUserID(c)).
+		Scan(&user.ID, &user.Email, &user.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// RequireAuth rejects requests without a valid "Authorization: Bearer <token>" header.
+// The token may be a login session token or an API key.
+func (h *AuthHandler) RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c.GetHeader("Authorization"))
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+
+		if isAPIKey(token) {
+			if h.authenticateAPIKey(c, token) {
+				c.Next()
+			}
+			return
+		}
+
+		tokenHash := hashToken(token)
+		var userID uuid.UUID
+		err := h.db.QueryRowContext(c.Request.Context(),
+			"SELECT user_id FROM sessions WHERE token_hash = $1 AND expires_at > now()", tokenHash).
+			Scan(&userID)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Database error checking session: %v", err)
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			return
+		}
+
+		c.Set(userIDKey, userID)
+		c.Set(tokenHashKey, tokenHash)
+		c.Next()
+	}
+}
+
+// createSession stores a new session for the user and returns its token
+func (h *AuthHandler) createSession(ctx context.Context, user models.User) (models.LoginResponse, error) {
+	token, err := newToken()
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+	expiresAt := time.Now().Add(h.sessionTTL)
+
+	_, err = h.db.ExecContext(ctx,
+		"INSERT INTO sessions (token_hash, user_id, expires_at) VALUES ($1, $2, $3)",
+		hashToken(token), user.ID, expiresAt)
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+
+	return models.LoginResponse{Token: token, ExpiresAt: expiresAt, User: user}, nil
+}
+
+// currentUserID returns the user set by RequireAuth
+func currentUserID(c *gin.Context) uuid.UUID {
+	id, _ := c.Get(userIDKey)
+	userID, _ := id.(uuid.UUID)
+	return userID
+}
+
+// bearerToken extracts the token from an Authorization header value
+func bearerToken(header string) (string, bool) {
+	const prefix = "Bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
+
+// newToken returns a random 256-bit token, hex encoded
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the SHA-256 hash under which a token is stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeEmail lowercases and trims an email address
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
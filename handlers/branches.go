@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"chat-app/models"
+	"chat-app/workflows"
+
+	"github.com/dbos-inc/dbos-transact-golang/dbos"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// branchPreviewLength is how many characters of a branch's last prompt are shown
+const branchPreviewLength = 80
+
+// EditMessage replaces a user message with new content by starting a new branch
+// beside it, and gets an AI response on that branch. The original is kept.
+func (h *ChatHandler) EditMessage(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+	messageID, err := uuid.Parse(c.Param("messageId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	var req models.SendMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if !h.ownsConversation(c, id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+	var role string
+	err = h.db.QueryRowContext(c.Request.Context(),
+		"SELECT role FROM messages WHERE id = $1 AND conversation_id = $2", messageID, id).Scan(&role)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if role != models.RoleUser {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only user messages can be edited"})
+		return
+	}
+
+	workflowID, ok := h.messageWorkflowID(c, id, req)
+	if !ok || !h.trackReply(c, id, workflowID) {
+		return
+	}
+
+	input := workflows.SendMessageInput{
+		ConversationID: id,
+		Content:        req.Content,
+		EditMessageID:  &messageID,
+		Caller:         currentCaller(c),
+	}
+	handle, err := dbos.RunWorkflow(h.dbosCtx, h.workflows.SendMessageWorkflow, input, messageWorkflowOptions(c, workflowID)...)
+	if err != nil {
+		log.Printf("Failed to start SendMessage workflow: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to edit message"})
+		return
+	}
+
+	output, err := handle.GetResult()
+	if err != nil {
+		if workflowQuotaExceeded(c, h.dbosCtx, workflowID, err) {
+			return
+		}
+		log.Printf("SendMessage workflow failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get AI response: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ChatResponse{
+		UserMessage:      output.UserMessage,
+		AssistantMessage: output.AssistantMessage,
+	})
+}
+
+// RegenerateMessage asks the model for a new reply to the user message behind a
+// message using DBOS workflow. The new reply is a sibling of the old one, which is kept.
+// The body is optional and may override the model and temperature for this reply.
+func (h *ChatHandler) RegenerateMessage(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+	messageID, err := uuid.Parse(c.Param("messageId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	var req models.RegenerateRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	if !h.ownsConversation(c, id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+	var exists bool
+	err = h.db.QueryRowContext(c.Request.Context(),
+		"SELECT EXISTS(SELECT 1 FROM messages WHERE id = $1 AND conversation_id = $2)",
+		messageID, id).Scan(&exists)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+
+	input := workflows.RegenerateInput{
+		ConversationID: id,
+		MessageID:      messageID,
+		Model:          req.Model,
+		Temperature:    req.Temperature,
+		Caller:         currentCaller(c),
+	}
+	workflowID, ok := h.messageWorkflowID(c, id, req)
+	if !ok || !h.trackReply(c, id, workflowID) {
+		return
+	}
+	handle, err := dbos.RunWorkflow(h.dbosCtx, h.workflows.RegenerateWorkflow, input, messageWorkflowOptions(c, workflowID)...)
+	if err != nil {
+		log.Printf("Failed to start Regenerate workflow: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to regenerate response"})
+		return
+	}
+
+	msg, err := handle.GetResult()
+	if err != nil {
+		if workflowQuotaExceeded(c, h.dbosCtx, workflowID, err) {
+			return
+		}
+		log.Printf("Regenerate workflow failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get AI response: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, msg)
+}
+
+// ListBranches lists every branch of a conversation, newest first. A branch is
+// identified by its last message; its preview is the prompt that led to it.
+func (h *ChatHandler) ListBranches(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	var active *uuid.UUID
+	err = h.db.QueryRowContext(c.Request.Context(),
+		"SELECT active_leaf_id FROM conversations WHERE id = $1 AND owner_id = $2",
+		id, currentUserID(c)).Scan(&active)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+
+	rows, err := h.db.QueryContext(c.Request.Context(),
+		"SELECT id, parent_id, role, content, created_at FROM messages WHERE conversation_id = $1 ORDER BY created_at DESC, id DESC",
+		id)
+	if err != nil {
+		log.Printf("Database error listing branches: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list branches"})
+		return
+	}
+	defer rows.Close()
+
+	var nodes []models.Message
+	byID := make(map[uuid.UUID]models.Message)
+	hasChildren := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var msg models.Message
+		if err := rows.Scan(&msg.ID, &msg.ParentID, &msg.Role, &msg.Content, &msg.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan message"})
+			return
+		}
+		nodes = append(nodes, msg)
+		byID[msg.ID] = msg
+		if msg.ParentID != nil {
+			hasChildren[*msg.ParentID] = true
+		}
+	}
+
+	branches := []models.Branch{}
+	for _, leaf := range nodes {
+		if hasChildren[leaf.ID] {
+			continue
+		}
+		branch := models.Branch{
+			LeafID:    leaf.ID,
+			CreatedAt: leaf.CreatedAt,
+			Active:    active != nil && *active == leaf.ID,
+		}
+		for msg, ok := leaf, true; ok; {
+			branch.Length++
+			if branch.Preview == "" && msg.Role == models.RoleUser {
+				branch.Preview = truncate(msg.Content, branchPreviewLength)
+			}
+			if msg.ParentID == nil {
+				break
+			}
+			msg, ok = byID[*msg.ParentID]
+		}
+		branches = append(branches, branch)
+	}
+
+	c.JSON(http.StatusOK, branches)
+}
+
+// SwitchBranch makes the branch containing a message the active one using DBOS workflow
+func (h *ChatHandler) SwitchBranch(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	var req models.SwitchBranchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	if !h.ownsConversation(c, id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+	var exists bool
+	err = h.db.QueryRowContext(c.Request.Context(),
+		"SELECT EXISTS(SELECT 1 FROM messages WHERE id = $1 AND conversation_id = $2)",
+		req.MessageID, id).Scan(&exists)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+
+	input := workflows.SwitchBranchInput{ConversationID: id, MessageID: req.MessageID}
+	handle, err := dbos.RunWorkflow(h.dbosCtx, h.workflows.SwitchBranchWorkflow, input)
+	if err != nil {
+		log.Printf("Failed to start SwitchBranch workflow: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to switch branch"})
+		return
+	}
+
+	conv, err := handle.GetResult()
+	if err != nil {
+		log.Printf("SwitchBranch workflow failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to switch branch"})
+		return
+	}
+
+	c.JSON(http.StatusOK, conv)
+}
+
+// addSiblings fills in SiblingIDs for messages that have alternatives on other branches
+func (h *ChatHandler) addSiblings(c *gin.Context, conversationID uuid.UUID, messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	rows, err := h.db.QueryContext(c.Request.Context(),
+		"SELECT id, parent_id FROM messages WHERE conversation_id = $1 ORDER BY created_at, id",
+		conversationID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// Children grouped by parent; uuid.Nil groups the roots
+	children := make(map[uuid.UUID][]uuid.UUID)
+	for rows.Next() {
+		var id uuid.UUID
+		var parentID *uuid.UUID
+		if err := rows.Scan(&id, &parentID); err != nil {
+			return err
+		}
+		key := uuid.Nil
+		if parentID != nil {
+			key = *parentID
+		}
+		children[key] = append(children[key], id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, msg := range messages {
+		key := uuid.Nil
+		if msg.ParentID != nil {
+			key = *msg.ParentID
+		}
+		if siblings := children[key]; len(siblings) > 1 {
+			messages[i].SiblingIDs = siblings
+		}
+	}
+	return nil
+}
+
+// truncate shortens text to at most n characters, adding an ellipsis if it was cut
+func truncate(text string, n int) string {
+	r := []rune(text)
+	if len(r) <= n {
+		return text
+	}
+	return string(r[:n]) + "…"
+}
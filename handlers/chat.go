@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"io"
 	"log"
 	"net/http"
+	"strings"
 
 	"chat-app/models"
 	"chat-app/services"
@@ -16,26 +20,45 @@ import (
 
 // ChatHandler handles chat-related HTTP requests
 type ChatHandler struct {
-	db          *sql.DB
-	vllmService *services.VLLMService
-	dbosCtx     dbos.DBOSContext
-	workflows   *workflows.ChatWorkflows
+	db        *sql.DB
+	provider  services.Provider
+	dbosCtx   dbos.DBOSContext
+	workflows *workflows.ChatWorkflows
 }
 
 // NewChatHandler creates a new chat handler
-func NewChatHandler(db *sql.DB, vllmService *services.VLLMService, dbosCtx dbos.DBOSContext, wf *workflows.ChatWorkflows) *ChatHandler {
+func NewChatHandler(db *sql.DB, provider services.Provider, dbosCtx dbos.DBOSContext, wf *workflows.ChatWorkflows) *ChatHandler {
 	return &ChatHandler{
-		db:          db,
-		vllmService: vllmService,
-		dbosCtx:     dbosCtx,
-		workflows:   wf,
+		db:        db,
+		provider:  provider,
+		dbosCtx:   dbosCtx,
+		workflows: wf,
 	}
 }
 
 // CreateConversation creates a new conversation using DBOS workflow
 func (h *ChatHandler) CreateConversation(c *gin.Context) {
+	// The body is optional; an empty body creates a conversation with provider defaults
+	var req models.CreateConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	if req.PersonaID != nil && !personaExists(c.Request.Context(), h.db, currentUserID(c), *req.PersonaID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Persona not found"})
+		return
+	}
+
 	// Run durable workflow
-	handle, err := dbos.RunWorkflow(h.dbosCtx, h.workflows.CreateConversationWorkflow, "")
+	input := workflows.CreateConversationInput{
+		OwnerID:      currentUserID(c),
+		Title:        strings.TrimSpace(req.Title),
+		SystemPrompt: req.SystemPrompt,
+		PersonaID:    req.PersonaID,
+		Params:       req.ModelParams,
+	}
+	handle, err := dbos.RunWorkflow(h.dbosCtx, h.workflows.CreateConversationWorkflow, input)
 	if err != nil {
 		log.Printf("Failed to start CreateConversation workflow: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create conversation"})
@@ -52,10 +75,18 @@ func (h *ChatHandler) CreateConversation(c *gin.Context) {
 	c.JSON(http.StatusCreated, conv)
 }
 
-// ListConversations lists all conversations
+// ListConversations lists the caller's conversations, newest first, one page at a time
 func (h *ChatHandler) ListConversations(c *gin.Context) {
+	page, err := parsePageQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	where, order, args := page.clause(2)
 	rows, err := h.db.QueryContext(c.Request.Context(),
-		"SELECT id, created_at FROM conversations ORDER BY created_at DESC")
+		"SELECT "+models.ConversationColumns+" FROM conversations WHERE owner_id = $1"+where+order,
+		append([]any{currentUserID(c)}, args...)...)
 	if err != nil {
 		log.Printf("Database error listing conversations: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list conversations"})
@@ -65,15 +96,28 @@ func (h *ChatHandler) ListConversations(c *gin.Context) {
 
 	conversations := []models.Conversation{}
 	for rows.Next() {
-		var conv models.Conversation
-		if err := rows.Scan(&conv.ID, &conv.CreatedAt); err != nil {
+		conv, err := models.ScanConversation(rows)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan conversation"})
 			return
 		}
 		conversations = append(conversations, conv)
 	}
 
-	c.JSON(http.StatusOK, conversations)
+	result := models.Page[models.Conversation]{Data: conversations}
+	if len(conversations) > page.limit {
+		result.Data = conversations[:page.limit]
+		result.HasMore = true
+	}
+	if n := len(result.Data); n > 0 {
+		last := result.Data[n-1]
+		result.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	if page.after {
+		reverse(result.Data)
+	}
+
+	c.JSON(http.StatusOK, result)
 }
 
 // GetConversation retrieves a conversation by ID
@@ -84,10 +128,9 @@ func (h *ChatHandler) GetConversation(c *gin.Context) {
 		return
 	}
 
-	var conv models.Conversation
-	err = h.db.QueryRowContext(c.Request.Context(),
-		"SELECT id, created_at FROM conversations WHERE id = $1", id).
-		Scan(&conv.ID, &conv.CreatedAt)
+	conv, err := models.ScanConversation(h.db.QueryRowContext(c.Request.Context(),
+		"SELECT "+models.ConversationColumns+" FROM conversations WHERE id = $1 AND owner_id = $2",
+		id, currentUserID(c)))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
 		return
@@ -96,6 +139,50 @@ func (h *ChatHandler) GetConversation(c *gin.Context) {
 	c.JSON(http.StatusOK, conv)
 }
 
+// UpdateConversation changes a conversation's settings using DBOS workflow
+func (h *ChatHandler) UpdateConversation(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	var req models.UpdateConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if req.PersonaID != nil && *req.PersonaID != "" &&
+		!personaExists(c.Request.Context(), h.db, currentUserID(c), uuid.MustParse(*req.PersonaID)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Persona not found"})
+		return
+	}
+
+	// Verify conversation exists and belongs to the caller
+	if !h.ownsConversation(c, id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+
+	// Run durable workflow
+	input := workflows.UpdateConversationInput{ConversationID: id, Update: req}
+	handle, err := dbos.RunWorkflow(h.dbosCtx, h.workflows.UpdateConversationWorkflow, input)
+	if err != nil {
+		log.Printf("Failed to start UpdateConversation workflow: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update conversation"})
+		return
+	}
+
+	conv, err := handle.GetResult()
+	if err != nil {
+		log.Printf("UpdateConversation workflow failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update conversation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, conv)
+}
+
 // DeleteConversation deletes a conversation using DBOS workflow
 func (h *ChatHandler) DeleteConversation(c *gin.Context) {
 	id, err := uuid.Parse(c.Param("id"))
@@ -104,6 +191,11 @@ func (h *ChatHandler) DeleteConversation(c *gin.Context) {
 		return
 	}
 
+	if !h.ownsConversation(c, id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+
 	// Run durable workflow
 	handle, err := dbos.RunWorkflow(h.dbosCtx, h.workflows.DeleteConversationWorkflow, id)
 	if err != nil {
@@ -122,7 +214,9 @@ func (h *ChatHandler) DeleteConversation(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Conversation deleted"})
 }
 
-// SendMessage sends a message and gets an AI response using DBOS workflow
+// SendMessage sends a message and gets an AI response using DBOS workflow.
+// With ?async=true (or "Prefer: respond-async") it returns 202 with the workflow
+// ID as soon as the workflow has started instead of waiting for the reply.
 func (h *ChatHandler) SendMessage(c *gin.Context) {
 	id, err := uuid.Parse(c.Param("id"))
 	if err != nil {
@@ -136,30 +230,46 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 		return
 	}
 
-	// Verify conversation exists
-	var exists bool
-	err = h.db.QueryRowContext(c.Request.Context(),
-		"SELECT EXISTS(SELECT 1 FROM conversations WHERE id = $1)", id).Scan(&exists)
-	if err != nil || !exists {
+	// Verify conversation exists and belongs to the caller
+	if !h.ownsConversation(c, id) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
 		return
 	}
 
+	workflowID, ok := h.messageWorkflowID(c, id, req)
+	if !ok || !h.trackReply(c, id, workflowID) {
+		return
+	}
+
 	// Run durable workflow for message processing
 	input := workflows.SendMessageInput{
 		ConversationID: id,
 		Content:        req.Content,
+		Caller:         currentCaller(c),
 	}
 
-	handle, err := dbos.RunWorkflow(h.dbosCtx, h.workflows.SendMessageWorkflow, input)
+	handle, err := dbos.RunWorkflow(h.dbosCtx, h.workflows.SendMessageWorkflow, input, messageWorkflowOptions(c, workflowID)...)
 	if err != nil {
 		log.Printf("Failed to start SendMessage workflow: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
 		return
 	}
 
+	// In async mode the client polls GET /api/workflows/:id for the reply
+	if asyncRequested(c) {
+		c.Header("Location", "/api/workflows/"+workflowID)
+		c.JSON(http.StatusAccepted, models.WorkflowStatus{
+			WorkflowID: workflowID,
+			Status:     models.WorkflowPending,
+		})
+		return
+	}
+
 	output, err := handle.GetResult()
 	if err != nil {
+		if workflowQuotaExceeded(c, h.dbosCtx, workflowID, err) {
+			return
+		}
 		log.Printf("SendMessage workflow failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get AI response: " + err.Error()})
 		return
@@ -171,7 +281,170 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 	})
 }
 
-// GetMessages retrieves all messages for a conversation
+// StreamMessage sends a message and relays the AI response token by token as Server-Sent Events.
+// The SendMessage workflow still durably persists both messages; the final "done" event carries them.
+func (h *ChatHandler) StreamMessage(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	var req models.SendMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	// Verify conversation exists and belongs to the caller
+	if !h.ownsConversation(c, id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+
+	workflowID, ok := h.messageWorkflowID(c, id, req)
+	if !ok || !h.trackReply(c, id, workflowID) {
+		return
+	}
+
+	input := workflows.SendMessageInput{
+		ConversationID: id,
+		Content:        req.Content,
+		Caller:         currentCaller(c),
+	}
+
+	// Subscribe before starting so no delta is missed
+	deltas, unsubscribe := h.workflows.Streams().Subscribe(workflowID)
+	defer unsubscribe()
+
+	handle, err := dbos.RunWorkflow(h.dbosCtx, h.workflows.SendMessageWorkflow, input, messageWorkflowOptions(c, workflowID)...)
+	if err != nil {
+		log.Printf("Failed to start SendMessage workflow: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		return
+	}
+
+	type result struct {
+		output workflows.SendMessageOutput
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		output, err := handle.GetResult()
+		done <- result{output, err}
+	}()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent("workflow", gin.H{"workflow_id": workflowID})
+	c.Writer.Flush()
+
+	// A reset means the reply is being generated again: discard the deltas so far
+	relay := func(event workflows.StreamEvent) {
+		if event.Reset {
+			c.SSEvent("reset", gin.H{})
+			return
+		}
+		c.SSEvent("delta", gin.H{"content": event.Delta})
+	}
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-deltas:
+			relay(event)
+			return true
+		case res := <-done:
+			// The reply is complete; flush any deltas still buffered before the final event
+			for drained := false; !drained; {
+				select {
+				case event := <-deltas:
+					relay(event)
+				default:
+					drained = true
+				}
+			}
+			if res.err != nil {
+				log.Printf("SendMessage workflow failed: %v", res.err)
+				c.SSEvent("error", gin.H{"error": "Failed to get AI response: " + res.err.Error()})
+				return false
+			}
+			c.SSEvent("done", models.ChatResponse{
+				UserMessage:      res.output.UserMessage,
+				AssistantMessage: res.output.AssistantMessage,
+			})
+			return false
+		case <-c.Request.Context().Done():
+			// Client went away; the workflow keeps running and persists the reply
+			return false
+		}
+	})
+}
+
+// CancelReply stops the reply being generated in a conversation. The workflow
+// producing it is cancelled in DBOS, so it runs no further steps; the model request
+// in progress is aborted, here or on whichever instance runs it, and the workflow
+// saves the text produced so far as a cancelled message.
+func (h *ChatHandler) CancelReply(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	var workflowID sql.NullString
+	err = h.db.QueryRowContext(c.Request.Context(),
+		"SELECT reply_workflow_id FROM conversations WHERE id = $1 AND owner_id = $2",
+		id, currentUserID(c)).Scan(&workflowID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get reply workflow: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel reply"})
+		return
+	}
+
+	// Only a workflow that has not finished yet can be cancelled
+	running := false
+	if workflowID.Valid {
+		statuses, err := dbos.ListWorkflows(h.dbosCtx,
+			dbos.WithWorkflowIDs([]string{workflowID.String}),
+			dbos.WithStatus([]dbos.WorkflowStatusType{dbos.WorkflowStatusPending, dbos.WorkflowStatusEnqueued}),
+			dbos.WithLoadInput(false),
+			dbos.WithLoadOutput(false))
+		if err != nil {
+			log.Printf("Failed to get workflow status: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel reply"})
+			return
+		}
+		running = len(statuses) > 0
+	}
+	if !running {
+		c.JSON(http.StatusConflict, gin.H{"error": "No reply is being generated"})
+		return
+	}
+
+	if err := dbos.CancelWorkflow(h.dbosCtx, workflowID.String); err != nil {
+		log.Printf("Failed to cancel workflow %s: %v", workflowID.String, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel reply"})
+		return
+	}
+	// Abort the model request at once if it runs in this process; other instances
+	// notice the cancellation when they next poll the workflow's status
+	h.workflows.CancelReply(workflowID.String)
+
+	c.JSON(http.StatusOK, models.WorkflowStatus{
+		WorkflowID: workflowID.String,
+		Status:     models.WorkflowCancelled,
+	})
+}
+
+// GetMessages retrieves a page of messages on a branch of the conversation, in
+// chronological order. The branch is the active one unless ?leaf= names the last
+// message of another. Without a cursor it returns the most recent page.
 func (h *ChatHandler) GetMessages(c *gin.Context) {
 	id, err := uuid.Parse(c.Param("id"))
 	if err != nil {
@@ -179,24 +452,89 @@ func (h *ChatHandler) GetMessages(c *gin.Context) {
 		return
 	}
 
-	rows, err := h.db.QueryContext(c.Request.Context(),
-		"SELECT id, conversation_id, role, content, created_at FROM messages WHERE conversation_id = $1 ORDER BY created_at ASC",
-		id)
+	page, err := parsePageQuery(c)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var leaf *uuid.UUID
+	err = h.db.QueryRowContext(c.Request.Context(),
+		"SELECT active_leaf_id FROM conversations WHERE id = $1 AND owner_id = $2",
+		id, currentUserID(c)).Scan(&leaf)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+	if v := c.Query("leaf"); v != "" {
+		leafID, err := uuid.Parse(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid leaf message ID"})
+			return
+		}
+		leaf = &leafID
+	}
+
+	messages := []models.Message{}
+	if leaf != nil {
+		where, order, args := page.clause(3)
+		messages, err = h.conversationMessages(c.Request.Context(), id, leaf, where+order, args...)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
+			return
+		}
+	}
+
+	result := models.Page[models.Message]{Data: messages}
+	if len(messages) > page.limit {
+		result.Data = messages[:page.limit]
+		result.HasMore = true
+	}
+	if n := len(result.Data); n > 0 {
+		last := result.Data[n-1]
+		result.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	if !page.after {
+		reverse(result.Data)
+	}
+
+	if err := h.addSiblings(c, id, result.Data); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
 		return
 	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// conversationMessages returns the messages on the branch of a conversation that
+// ends at leaf, or every message of the conversation if leaf is nil. clause is
+// appended to the query to filter and order them; its placeholders start at $3.
+func (h *ChatHandler) conversationMessages(ctx context.Context, conversationID uuid.UUID, leaf *uuid.UUID, clause string, args ...any) ([]models.Message, error) {
+	rows, err := h.db.QueryContext(ctx,
+		models.BranchCTE+"SELECT "+models.MessageColumns+" FROM messages "+
+			"WHERE conversation_id = $2 AND ($1::uuid IS NULL OR id IN (SELECT msg_id FROM branch))"+clause,
+		append([]any{leaf, conversationID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	messages := []models.Message{}
 	for rows.Next() {
-		var msg models.Message
-		if err := rows.Scan(&msg.ID, &msg.ConversationID, &msg.Role, &msg.Content, &msg.CreatedAt); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan message"})
-			return
+		msg, err := models.ScanMessage(rows)
+		if err != nil {
+			return nil, err
 		}
 		messages = append(messages, msg)
 	}
+	return messages, rows.Err()
+}
 
-	c.JSON(http.StatusOK, messages)
+// ownsConversation reports whether the conversation exists and belongs to the authenticated user
+func (h *ChatHandler) ownsConversation(c *gin.Context, id uuid.UUID) bool {
+	var exists bool
+	err := h.db.QueryRowContext(c.Request.Context(),
+		"SELECT EXISTS(SELECT 1 FROM conversations WHERE id = $1 AND owner_id = $2)",
+		id, currentUserID(c)).Scan(&exists)
+	return err == nil && exists
 }
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"chat-app/models"
+	"chat-app/workflows"
+
+	"github.com/dbos-inc/dbos-transact-golang/dbos"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxDocumentBytes bounds the size of an uploaded document
+	maxDocumentBytes = 10 << 20
+	// defaultDocumentSearchLimit is the number of chunks returned by semantic search
+	defaultDocumentSearchLimit = 10
+)
+
+// DocumentHandler handles document upload and semantic search HTTP requests
+type DocumentHandler struct {
+	db        *sql.DB
+	dbosCtx   dbos.DBOSContext
+	workflows *workflows.ChatWorkflows
+}
+
+// NewDocumentHandler creates a new document handler
+func NewDocumentHandler(db *sql.DB, dbosCtx dbos.DBOSContext, wf *workflows.ChatWorkflows) *DocumentHandler {
+	return &DocumentHandler{
+		db:        db,
+		dbosCtx:   dbosCtx,
+		workflows: wf,
+	}
+}
+
+// UploadDocument accepts a text or Markdown document, either as a multipart "file"
+// (with an optional "title" field) or as JSON, and ingests it in the background.
+// It responds 202 with the document in status "processing".
+func (h *DocumentHandler) UploadDocument(c *gin.Context) {
+	if !h.workflows.DocumentsEnabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Document ingestion is not configured (set EMBEDDING_MODEL)"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxDocumentBytes+64<<10)
+
+	var title, filename, content string
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file: " + err.Error()})
+			return
+		}
+		if file.Size > maxDocumentBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Document is too large"})
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+			return
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+			return
+		}
+		filename = filepath.Base(file.Filename)
+		title = strings.TrimSpace(c.PostForm("title"))
+		if title == "" {
+			title = strings.TrimSuffix(filename, filepath.Ext(filename))
+		}
+		content = string(data)
+	} else {
+		var req models.UploadDocumentRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+			return
+		}
+		if len(req.Content) > maxDocumentBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Document is too large"})
+			return
+		}
+		title, content = strings.TrimSpace(req.Title), req.Content
+	}
+
+	// Only text can be chunked; Postgres TEXT also rejects NUL bytes
+	if !utf8.ValidString(content) || strings.ContainsRune(content, 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Document must be UTF-8 text"})
+		return
+	}
+	if strings.TrimSpace(content) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Document is empty"})
+		return
+	}
+
+	// Store the text first so the workflow only needs the document ID
+	doc, err := h.workflows.CreateDocument(c.Request.Context(), workflows.NewDocument{
+		OwnerID:  currentUserID(c),
+		Title:    title,
+		Filename: filename,
+		Content:  content,
+	})
+	if err != nil {
+		log.Printf("Database error storing document: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload document"})
+		return
+	}
+
+	// The document ID doubles as the workflow ID, so ingestion runs once per upload
+	if _, err := dbos.RunWorkflow(h.dbosCtx, h.workflows.IngestDocumentWorkflow, doc.ID,
+		dbos.WithWorkflowID(doc.ID.String())); err != nil {
+		log.Printf("Failed to start IngestDocument workflow: %v", err)
+		if _, err := h.db.ExecContext(c.Request.Context(), "DELETE FROM documents WHERE id = $1", doc.ID); err != nil {
+			log.Printf("Failed to remove document %s: %v", doc.ID, err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload document"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, doc)
+}
+
+// ListDocuments lists the caller's documents, newest first, one page at a time
+func (h *DocumentHandler) ListDocuments(c *gin.Context) {
+	page, err := parsePageQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	where, order, args := page.clause(2)
+	rows, err := h.db.QueryContext(c.Request.Context(),
+		"SELECT "+models.DocumentColumns+" FROM documents WHERE owner_id = $1"+where+order,
+		append([]any{currentUserID(c)}, args...)...)
+	if err != nil {
+		log.Printf("Database error listing documents: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list documents"})
+		return
+	}
+	defer rows.Close()
+
+	documents := []models.Document{}
+	for rows.Next() {
+		doc, err := models.ScanDocument(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan document"})
+			return
+		}
+		documents = append(documents, doc)
+	}
+
+	result := models.Page[models.Document]{Data: documents}
+	if len(documents) > page.limit {
+		result.Data = documents[:page.limit]
+		result.HasMore = true
+	}
+	if n := len(result.Data); n > 0 {
+		last := result.Data[n-1]
+		result.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	if page.after {
+		reverse(result.Data)
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetDocument retrieves a document by ID, including its ingestion status
+func (h *DocumentHandler) GetDocument(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+
+	doc, err := models.ScanDocument(h.db.QueryRowContext(c.Request.Context(),
+		"SELECT "+models.DocumentColumns+" FROM documents WHERE id = $1 AND owner_id = $2",
+		id, currentUserID(c)))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, doc)
+}
+
+// DeleteDocument deletes a document and its chunks using DBOS workflow
+func (h *DocumentHandler) DeleteDocument(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+
+	var exists bool
+	err = h.db.QueryRowContext(c.Request.Context(),
+		"SELECT EXISTS(SELECT 1 FROM documents WHERE id = $1 AND owner_id = $2)",
+		id, currentUserID(c)).Scan(&exists)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+
+	handle, err := dbos.RunWorkflow(h.dbosCtx, h.workflows.DeleteDocumentWorkflow, id)
+	if err != nil {
+		log.Printf("Failed to start DeleteDocument workflow: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
+		return
+	}
+
+	if _, err := handle.GetResult(); err != nil {
+		log.Printf("DeleteDocument workflow failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Document deleted"})
+}
+
+// SearchDocuments returns the caller's document chunks most similar in meaning to ?q=
+func (h *DocumentHandler) SearchDocuments(c *gin.Context) {
+	if !h.workflows.DocumentsEnabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Document search is not configured (set EMBEDDING_MODEL)"})
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is empty"})
+		return
+	}
+	limit := defaultDocumentSearchLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = min(n, maxPageLimit)
+	}
+
+	matches, err := h.workflows.SearchDocuments(c.Request.Context(), currentUserID(c), query, limit, 0)
+	if err != nil {
+		log.Printf("Document search failed: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to search documents"})
+		return
+	}
+	if matches == nil {
+		matches = []models.DocumentMatch{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": matches})
+}
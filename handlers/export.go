@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"chat-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// exportFormat is a transcript format with its file extension and content type
+type exportFormat struct {
+	ext         string
+	contentType string
+}
+
+var exportFormats = map[string]exportFormat{
+	"json":     {"json", "application/json"},
+	"markdown": {"md", "text/markdown; charset=utf-8"},
+	"jsonl":    {"jsonl", "application/x-ndjson"},
+}
+
+// parseExportFormat reads ?format=, defaulting to json
+func parseExportFormat(c *gin.Context) (string, bool) {
+	format := c.DefaultQuery("format", "json")
+	if _, ok := exportFormats[format]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format: use json, markdown or jsonl"})
+		return "", false
+	}
+	return format, true
+}
+
+// ExportConversation downloads a conversation's messages on all branches with its
+// metadata, as ?format=json (default), markdown (active branch only) or jsonl
+func (h *ChatHandler) ExportConversation(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+	format, ok := parseExportFormat(c)
+	if !ok {
+		return
+	}
+
+	conv, err := models.ScanConversation(h.db.QueryRowContext(c.Request.Context(),
+		"SELECT "+models.ConversationColumns+" FROM conversations WHERE id = $1 AND owner_id = $2",
+		id, currentUserID(c)))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+	export, err := h.loadExport(c.Request.Context(), conv)
+	if err != nil {
+		log.Printf("Database error exporting conversation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export conversation"})
+		return
+	}
+
+	c.Header("Content-Type", exportFormats[format].contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFilename(conv, format)))
+	c.Status(http.StatusOK)
+	if err := writeExport(c.Writer, format, export); err != nil {
+		log.Printf("Failed to write conversation export: %v", err)
+	}
+}
+
+// ExportConversations downloads every conversation of the caller as a zip archive
+// with one transcript per conversation, in ?format=json (default), markdown or jsonl.
+// The archive is streamed, so a failure part way leaves it truncated.
+func (h *ChatHandler) ExportConversations(c *gin.Context) {
+	format, ok := parseExportFormat(c)
+	if !ok {
+		return
+	}
+
+	rows, err := h.db.QueryContext(c.Request.Context(),
+		"SELECT "+models.ConversationColumns+" FROM conversations WHERE owner_id = $1 ORDER BY created_at, id",
+		currentUserID(c))
+	if err != nil {
+		log.Printf("Database error listing conversations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export conversations"})
+		return
+	}
+	var conversations []models.Conversation
+	for rows.Next() {
+		conv, err := models.ScanConversation(rows)
+		if err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan conversation"})
+			return
+		}
+		conversations = append(conversations, conv)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("Database error listing conversations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export conversations"})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="conversations.zip"`)
+	c.Status(http.StatusOK)
+
+	archive := zip.NewWriter(c.Writer)
+	for _, conv := range conversations {
+		export, err := h.loadExport(c.Request.Context(), conv)
+		if err != nil {
+			log.Printf("Database error exporting conversation %s: %v", conv.ID, err)
+			return
+		}
+		f, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     exportFilename(conv, format),
+			Method:   zip.Deflate,
+			Modified: conv.CreatedAt,
+		})
+		if err != nil {
+			log.Printf("Failed to add conversation %s to export: %v", conv.ID, err)
+			return
+		}
+		if err := writeExport(f, format, export); err != nil {
+			log.Printf("Failed to write conversation %s to export: %v", conv.ID, err)
+			return
+		}
+		c.Writer.Flush()
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Failed to finish conversations export: %v", err)
+	}
+}
+
+// loadExport collects every message of a conversation, on all branches, and the
+// IDs of those on its active branch
+func (h *ChatHandler) loadExport(ctx context.Context, conv models.Conversation) (models.ConversationExport, error) {
+	messages, err := h.conversationMessages(ctx, conv.ID, nil, " ORDER BY created_at, id")
+	if err != nil {
+		return models.ConversationExport{}, err
+	}
+	return models.ConversationExport{
+		Conversation: conv,
+		ExportedAt:   time.Now(),
+		Messages:     messages,
+		ActiveBranch: activeBranch(messages, conv.ActiveLeafID),
+	}, nil
+}
+
+// activeBranch walks the parent links from leaf back to the first message and
+// returns the IDs on the way, first message first
+func activeBranch(messages []models.Message, leaf *uuid.UUID) []uuid.UUID {
+	parents := make(map[uuid.UUID]*uuid.UUID, len(messages))
+	for _, msg := range messages {
+		parents[msg.ID] = msg.ParentID
+	}
+	branch := []uuid.UUID{}
+	for id := leaf; id != nil; {
+		parent, ok := parents[*id]
+		if !ok {
+			break
+		}
+		branch = append(branch, *id)
+		id = parent
+	}
+	slices.Reverse(branch)
+	return branch
+}
+
+// exportFilename names a conversation's transcript file by its creation date and ID
+func exportFilename(conv models.Conversation, format string) string {
+	return fmt.Sprintf("%s-%s.%s", conv.CreatedAt.Format("2006-01-02"), conv.ID, exportFormats[format].ext)
+}
+
+// writeExport writes a transcript in the given format
+func writeExport(w io.Writer, format string, export models.ConversationExport) error {
+	switch format {
+	case "markdown":
+		return writeMarkdownExport(w, export)
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(models.ExportRecord{Type: "conversation", Conversation: &export.Conversation, ExportedAt: &export.ExportedAt, ActiveBranch: export.ActiveBranch}); err != nil {
+			return err
+		}
+		for i := range export.Messages {
+			if err := enc.Encode(models.ExportRecord{Type: "message", Message: &export.Messages[i]}); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(export)
+	}
+}
+
+// markdownRoles are the headings of each message role in a Markdown transcript
+var markdownRoles = map[string]string{
+	models.RoleUser:       "User",
+	models.RoleAssistant:  "Assistant",
+	models.RoleToolCall:   "Tool call",
+	models.RoleToolResult: "Tool result",
+}
+
+// writeMarkdownExport writes the active branch as a human-readable Markdown
+// document; other branches are left out
+func writeMarkdownExport(w io.Writer, export models.ConversationExport) error {
+	conv := export.Conversation
+	b := bufio.NewWriter(w)
+
+	byID := make(map[uuid.UUID]models.Message, len(export.Messages))
+	for _, msg := range export.Messages {
+		byID[msg.ID] = msg
+	}
+	messages := make([]models.Message, len(export.ActiveBranch))
+	for i, id := range export.ActiveBranch {
+		messages[i] = byID[id]
+	}
+
+	title := conv.Title
+	if title == "" {
+		title = "Chat " + conv.CreatedAt.Format("2006-01-02")
+	}
+	fmt.Fprintf(b, "# %s\n\n", title)
+	fmt.Fprintf(b, "- Conversation: `%s`\n", conv.ID)
+	fmt.Fprintf(b, "- Created: %s\n", conv.CreatedAt.Format(time.RFC3339))
+	if conv.Model != nil {
+		fmt.Fprintf(b, "- Model: %s\n", *conv.Model)
+	}
+	fmt.Fprintf(b, "- Messages: %d\n", len(messages))
+	fmt.Fprintf(b, "- Exported: %s\n", export.ExportedAt.Format(time.RFC3339))
+	if conv.SystemPrompt != nil && *conv.SystemPrompt != "" {
+		fmt.Fprintf(b, "\n## System prompt\n\n%s\n", *conv.SystemPrompt)
+	}
+
+	for _, msg := range messages {
+		role := markdownRoles[msg.Role]
+		if role == "" {
+			role = msg.Role
+		}
+		var details []string
+		if msg.Model != nil {
+			details = append(details, *msg.Model)
+		}
+		if msg.Usage != nil {
+			details = append(details, fmt.Sprintf("%d tokens", msg.Usage.TotalTokens))
+		}
+		if msg.Status == models.MessageCancelled {
+			details = append(details, "stopped")
+		}
+		fmt.Fprintf(b, "\n---\n\n### %s · %s", role, msg.CreatedAt.Format("2006-01-02 15:04"))
+		if len(details) > 0 {
+			fmt.Fprintf(b, " (%s)", strings.Join(details, ", "))
+		}
+		b.WriteString("\n\n")
+
+		switch msg.Role {
+		case models.RoleToolCall:
+			if msg.Content != "" {
+				fmt.Fprintf(b, "%s\n\n", msg.Content)
+			}
+			for _, call := range msg.ToolCalls {
+				fmt.Fprintf(b, "`%s`\n\n```json\n%s\n```\n", call.Name, call.Arguments)
+			}
+		case models.RoleToolResult:
+			fmt.Fprintf(b, "```\n%s\n```\n", msg.Content)
+		default:
+			fmt.Fprintf(b, "%s\n", msg.Content)
+		}
+	}
+	return b.Flush()
+}
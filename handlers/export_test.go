@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"chat-app/models"
+
+	"github.com/google/uuid"
+)
+
+func TestActiveBranch(t *testing.T) {
+	// root -> reply -> edit1 and root -> reply -> edit2 -> reply2
+	root, reply, edit1, edit2, reply2 := uuid.New(), uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	messages := []models.Message{
+		{ID: root},
+		{ID: reply, ParentID: &root},
+		{ID: edit1, ParentID: &reply},
+		{ID: edit2, ParentID: &reply},
+		{ID: reply2, ParentID: &edit2},
+	}
+	missing := uuid.New()
+	tests := []struct {
+		name string
+		leaf *uuid.UUID
+		want []uuid.UUID
+	}{
+		{"deepest branch", &reply2, []uuid.UUID{root, reply, edit2, reply2}},
+		{"sibling branch", &edit1, []uuid.UUID{root, reply, edit1}},
+		{"first message", &root, []uuid.UUID{root}},
+		{"no active leaf", nil, []uuid.UUID{}},
+		{"unknown leaf", &missing, []uuid.UUID{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := activeBranch(messages, tt.leaf); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("activeBranch = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"chat-app/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModelHandler handles model discovery HTTP requests
+type ModelHandler struct {
+	catalog *services.ModelCatalog
+}
+
+// NewModelHandler creates a new model handler
+func NewModelHandler(catalog *services.ModelCatalog) *ModelHandler {
+	return &ModelHandler{catalog: catalog}
+}
+
+// ListModels returns the models the configured providers can answer with
+func (h *ModelHandler) ListModels(c *gin.Context) {
+	list, err := h.catalog.Models(c.Request.Context())
+	if err != nil {
+		log.Printf("Failed to list models: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to list models"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chat-app/models"
+	"chat-app/services"
+	"chat-app/workflows"
+
+	"github.com/dbos-inc/dbos-transact-golang/dbos"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// OpenAIHandler serves an OpenAI-compatible API in front of the provider layer, so
+// tools that speak the Chat Completions protocol can use the app's models, keys
+// and rate limits
+type OpenAIHandler struct {
+	dbosCtx   dbos.DBOSContext
+	workflows *workflows.ChatWorkflows
+	catalog   *services.ModelCatalog
+	// logConversations saves completions as conversations unless a request opts out
+	logConversations bool
+}
+
+// NewOpenAIHandler creates a new OpenAI-compatible API handler
+func NewOpenAIHandler(dbosCtx dbos.DBOSContext, wf *workflows.ChatWorkflows, catalog *services.ModelCatalog, logConversations bool) *OpenAIHandler {
+	return &OpenAIHandler{
+		dbosCtx:          dbosCtx,
+		workflows:        wf,
+		catalog:          catalog,
+		logConversations: logConversations,
+	}
+}
+
+// openAIError answers with an error in OpenAI's format
+func openAIError(c *gin.Context, status int, errType, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"error": gin.H{"message": message, "type": errType}})
+}
+
+// ListModels returns the catalog's models in OpenAI's format
+func (h *OpenAIHandler) ListModels(c *gin.Context) {
+	list, err := h.catalog.Models(c.Request.Context())
+	if err != nil {
+		log.Printf("Failed to list models: %v", err)
+		openAIError(c, http.StatusBadGateway, "api_error", "Failed to list models")
+		return
+	}
+	data := make([]models.OpenAIModel, len(list))
+	for i, m := range list {
+		data[i] = models.OpenAIModel{
+			ID:          m.ID,
+			Object:      "model",
+			OwnedBy:     m.Provider,
+			MaxModelLen: m.MaxContextLength,
+		}
+	}
+	c.JSON(http.StatusOK, models.OpenAIModelList{Object: "list", Data: data})
+}
+
+// ChatCompletions answers an OpenAI chat completion request, streamed as
+// chat.completion.chunk events if it sets stream. The X-Log-Conversation header
+// overrides whether the exchange is saved as a conversation.
+func (h *OpenAIHandler) ChatCompletions(c *gin.Context) {
+	var req models.OpenAIChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "Invalid request body: "+err.Error())
+		return
+	}
+	chatReq, err := openAIChatRequest(req)
+	if err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	// Reject models no provider serves; if the catalog is unavailable, let the provider decide
+	if list, err := h.catalog.Models(c.Request.Context()); err == nil && len(list) > 0 {
+		known := false
+		for _, m := range list {
+			known = known || m.ID == req.Model
+		}
+		if !known {
+			openAIError(c, http.StatusNotFound, "invalid_request_error", fmt.Sprintf("The model %q does not exist", req.Model))
+			return
+		}
+	}
+
+	logConversation := h.logConversations
+	if v := c.GetHeader("X-Log-Conversation"); v != "" {
+		if logConversation, err = strconv.ParseBool(v); err != nil {
+			openAIError(c, http.StatusBadRequest, "invalid_request_error", "X-Log-Conversation must be true or false")
+			return
+		}
+	}
+
+	workflowID := uuid.NewString()
+	input := workflows.CompletionInput{
+		Caller:  currentCaller(c),
+		Request: chatReq,
+		Log:     logConversation,
+	}
+	if !h.checkTokens(c, input.Caller) {
+		return
+	}
+	if req.Stream {
+		h.streamCompletion(c, req, workflowID, input)
+		return
+	}
+
+	handle, err := dbos.RunWorkflow(h.dbosCtx, h.workflows.CompletionWorkflow, input, messageWorkflowOptions(c, workflowID)...)
+	if err != nil {
+		log.Printf("Failed to start Completion workflow: %v", err)
+		openAIError(c, http.StatusInternalServerError, "api_error", "Failed to start completion")
+		return
+	}
+	output, err := handle.GetResult()
+	if err != nil {
+		if quotaExceeded(c, err) {
+			return
+		}
+		log.Printf("Completion workflow failed: %v", err)
+		openAIError(c, http.StatusBadGateway, "api_error", "Failed to get completion")
+		return
+	}
+
+	finishReason := openAIFinishReason(output.ChatResult)
+	c.JSON(http.StatusOK, models.OpenAIChatResponse{
+		ID:      "chatcmpl-" + workflowID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   responseModel(req, output.ChatResult),
+		Choices: []models.OpenAIChoice{{
+			Message:      &models.OpenAIReplyMessage{Role: models.RoleAssistant, Content: output.Content},
+			FinishReason: &finishReason,
+		}},
+		Usage:          output.Usage,
+		ConversationID: output.ConversationID,
+	})
+}
+
+// checkTokens refuses a completion for a caller over a daily token limit with 429
+// and Retry-After before the workflow starts; once a stream has started, a quota
+// failure could only be an error chunk. It returns false if it wrote a response.
+func (h *OpenAIHandler) checkTokens(c *gin.Context, caller workflows.Caller) bool {
+	err := h.workflows.Quotas().CheckTokens(c.Request.Context(), caller)
+	if err == nil {
+		return true
+	}
+	if !quotaExceeded(c, err) {
+		log.Printf("Failed to check rate limits: %v", err)
+		openAIError(c, http.StatusInternalServerError, "api_error", "Failed to check rate limits")
+	}
+	return false
+}
+
+// streamCompletion runs a completion and relays it as Server-Sent Events in
+// OpenAI's chunk format, ending with "data: [DONE]"
+func (h *OpenAIHandler) streamCompletion(c *gin.Context, req models.OpenAIChatRequest, workflowID string, input workflows.CompletionInput) {
+	// Subscribe before starting so no delta is missed
+	deltas, unsubscribe := h.workflows.Streams().Subscribe(workflowID)
+	defer unsubscribe()
+
+	handle, err := dbos.RunWorkflow(h.dbosCtx, h.workflows.CompletionWorkflow, input, messageWorkflowOptions(c, workflowID)...)
+	if err != nil {
+		log.Printf("Failed to start Completion workflow: %v", err)
+		openAIError(c, http.StatusInternalServerError, "api_error", "Failed to start completion")
+		return
+	}
+
+	type result struct {
+		output workflows.CompletionOutput
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		output, err := handle.GetResult()
+		done <- result{output, err}
+	}()
+
+	created := time.Now().Unix()
+	chunk := func(delta *models.OpenAIReplyMessage, finishReason *string) models.OpenAIChatResponse {
+		return models.OpenAIChatResponse{
+			ID:      "chatcmpl-" + workflowID,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   req.Model,
+			Choices: []models.OpenAIChoice{{Delta: delta, FinishReason: finishReason}},
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	writeSSEData(c.Writer, chunk(&models.OpenAIReplyMessage{Role: models.RoleAssistant}, nil))
+	c.Writer.Flush()
+
+	// OpenAI's chunk format cannot take back content, so a reply that is generated
+	// again after part of it was relayed ends the stream with an error
+	relay := func(w io.Writer, event workflows.StreamEvent) bool {
+		if event.Reset {
+			writeSSEData(w, gin.H{"error": gin.H{"message": "The completion was interrupted mid-stream; retry the request", "type": "api_error"}})
+			return false
+		}
+		writeSSEData(w, chunk(&models.OpenAIReplyMessage{Content: event.Delta}, nil))
+		return true
+	}
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-deltas:
+			return relay(w, event)
+		case res := <-done:
+			// The reply is complete; flush any deltas still buffered before the final chunk
+			for drained := false; !drained; {
+				select {
+				case event := <-deltas:
+					if !relay(w, event) {
+						return false
+					}
+				default:
+					drained = true
+				}
+			}
+			if res.err != nil {
+				log.Printf("Completion workflow failed: %v", res.err)
+				writeSSEData(w, gin.H{"error": gin.H{"message": "Failed to get completion", "type": "api_error"}})
+				return false
+			}
+			finishReason := openAIFinishReason(res.output.ChatResult)
+			final := chunk(&models.OpenAIReplyMessage{}, &finishReason)
+			final.Model = responseModel(req, res.output.ChatResult)
+			final.ConversationID = res.output.ConversationID
+			writeSSEData(w, final)
+			if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+				usage := chunk(nil, nil)
+				usage.Model = final.Model
+				usage.Choices = []models.OpenAIChoice{}
+				usage.Usage = res.output.Usage
+				writeSSEData(w, usage)
+			}
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return false
+		case <-c.Request.Context().Done():
+			// Client went away; the workflow still finishes and charges the caller
+			return false
+		}
+	})
+}
+
+// writeSSEData writes v as the JSON data of an unnamed Server-Sent Event
+func writeSSEData(w io.Writer, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to encode stream chunk: %v", err)
+		return
+	}
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+// openAIChatRequest converts an OpenAI request to a provider request. System and
+// developer messages become the system prompt; the last message must be the
+// user's, and everything before it is history.
+func openAIChatRequest(req models.OpenAIChatRequest) (services.ChatRequest, error) {
+	last := req.Messages[len(req.Messages)-1]
+	if last.Role != models.RoleUser {
+		return services.ChatRequest{}, fmt.Errorf("the last message must have role \"user\"")
+	}
+
+	var system []string
+	var history []models.Message
+	for _, msg := range req.Messages[:len(req.Messages)-1] {
+		switch msg.Role {
+		case "system", "developer":
+			system = append(system, string(msg.Content))
+		default:
+			history = append(history, models.Message{Role: msg.Role, Content: string(msg.Content)})
+		}
+	}
+
+	params := models.ModelParams{
+		Model:       &req.Model,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		MaxTokens:   req.MaxTokens,
+	}
+	if req.MaxCompletionTokens != nil {
+		params.MaxTokens = req.MaxCompletionTokens
+	}
+	if len(req.Stop) > 0 {
+		params.StopSequences = req.Stop
+	}
+	return services.ChatRequest{
+		System:      strings.Join(system, "\n\n"),
+		Messages:    history,
+		UserMessage: string(last.Content),
+		Params:      params,
+	}, nil
+}
+
+// openAIFinishReason reports why generation stopped, "stop" if the backend did not say
+func openAIFinishReason(result services.ChatResult) string {
+	if result.FinishReason == "" {
+		return "stop"
+	}
+	return result.FinishReason
+}
+
+// responseModel names the model that answered, falling back to the one requested
+func responseModel(req models.OpenAIChatRequest, result services.ChatResult) string {
+	if result.Model != "" {
+		return result.Model
+	}
+	return req.Model
+}
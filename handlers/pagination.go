@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+	// cursorTimeLayout matches the precision of Postgres TIMESTAMP columns
+	cursorTimeLayout = "2006-01-02 15:04:05.999999"
+)
+
+// pageQuery is a parsed ?limit=&before=&after= query
+type pageQuery struct {
+	limit     int
+	createdAt string // cursor position, formatted with cursorTimeLayout; empty for the first page
+	id        uuid.UUID
+	after     bool // page forward (newer items) instead of backward (older items)
+}
+
+// parsePageQuery reads the pagination query parameters. Only one of before/after may be set.
+func parsePageQuery(c *gin.Context) (pageQuery, error) {
+	q := pageQuery{limit: defaultPageLimit}
+
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return q, fmt.Errorf("invalid limit")
+		}
+		q.limit = min(n, maxPageLimit)
+	}
+
+	before, after := c.Query("before"), c.Query("after")
+	if before != "" && after != "" {
+		return q, fmt.Errorf("only one of before and after may be given")
+	}
+	cursor := before
+	if after != "" {
+		cursor, q.after = after, true
+	}
+	if cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			return q, fmt.Errorf("invalid cursor")
+		}
+		q.createdAt, q.id = createdAt, id
+	}
+	return q, nil
+}
+
+// clause returns the keyset condition (starting with " AND ", or empty), the ORDER BY
+// and LIMIT suffix, and their arguments. Placeholders are numbered from next.
+// One extra row is requested so callers can tell whether there are more.
+func (q pageQuery) clause(next int) (string, string, []any) {
+	var where string
+	var args []any
+	if q.createdAt != "" {
+		op := "<"
+		if q.after {
+			op = ">"
+		}
+		where = fmt.Sprintf(" AND (created_at, id) %s ($%d::timestamp, $%d)", op, next, next+1)
+		args = append(args, q.createdAt, q.id)
+		next += 2
+	}
+
+	dir := "DESC"
+	if q.after {
+		dir = "ASC"
+	}
+	order := fmt.Sprintf(" ORDER BY created_at %s, id %s LIMIT $%d", dir, dir, next)
+	args = append(args, q.limit+1)
+	return where, order, args
+}
+
+// encodeCursor builds an opaque cursor for an item's position
+func encodeCursor(createdAt time.Time, id uuid.UUID) string {
+	raw := createdAt.Format(cursorTimeLayout) + "|" + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor parses a cursor built by encodeCursor
+func decodeCursor(cursor string) (string, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", uuid.Nil, err
+	}
+	ts, idStr, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return "", uuid.Nil, fmt.Errorf("malformed cursor")
+	}
+	if _, err := time.Parse(cursorTimeLayout, ts); err != nil {
+		return "", uuid.Nil, err
+	}
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return "", uuid.Nil, err
+	}
+	return ts, id, nil
+}
+
+// reverse reverses a slice in place
+func reverse[T any](items []T) {
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+}
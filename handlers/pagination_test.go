@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := uuid.New()
+	tests := []struct {
+		name      string
+		createdAt time.Time
+		want      string
+	}{
+		{"microseconds", time.Date(2024, 3, 5, 14, 7, 9, 123456000, time.UTC), "2024-03-05 14:07:09.123456"},
+		{"trailing zeros dropped", time.Date(2024, 3, 5, 14, 7, 9, 120000000, time.UTC), "2024-03-05 14:07:09.12"},
+		{"whole second", time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC), "2024-03-05 14:07:09"},
+		{"nanoseconds truncated", time.Date(2024, 3, 5, 14, 7, 9, 123456789, time.UTC), "2024-03-05 14:07:09.123456"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := encodeCursor(tt.createdAt, id)
+			createdAt, gotID, err := decodeCursor(cursor)
+			if err != nil {
+				t.Fatalf("decodeCursor(%q): %v", cursor, err)
+			}
+			if createdAt != tt.want || gotID != id {
+				t.Errorf("decodeCursor = (%q, %s), want (%q, %s)", createdAt, gotID, tt.want, id)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorRejectsMalformed(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := map[string]string{
+		"not base64":   "!!!",
+		"padded":       base64.URLEncoding.EncodeToString([]byte("2024-03-05 14:07:09|" + uuid.NewString())),
+		"no separator": encode("2024-03-05 14:07:09"),
+		"bad time":     encode("yesterday|" + uuid.NewString()),
+		"bad id":       encode("2024-03-05 14:07:09|not-a-uuid"),
+		"empty":        "",
+	}
+	for name, cursor := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := decodeCursor(cursor); err == nil {
+				t.Errorf("decodeCursor(%q) succeeded, want error", cursor)
+			}
+		})
+	}
+}
+
+func TestParsePageQuery(t *testing.T) {
+	id := uuid.New()
+	cursor := encodeCursor(time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC), id)
+	tests := []struct {
+		name    string
+		query   string
+		want    pageQuery
+		wantErr bool
+	}{
+		{name: "defaults", query: "", want: pageQuery{limit: defaultPageLimit}},
+		{name: "limit", query: "limit=10", want: pageQuery{limit: 10}},
+		{name: "limit capped", query: "limit=1000", want: pageQuery{limit: maxPageLimit}},
+		{name: "zero limit", query: "limit=0", wantErr: true},
+		{name: "non-numeric limit", query: "limit=ten", wantErr: true},
+		{name: "before", query: "before=" + cursor, want: pageQuery{limit: defaultPageLimit, createdAt: "2024-03-05 14:07:09", id: id}},
+		{name: "after", query: "after=" + cursor, want: pageQuery{limit: defaultPageLimit, createdAt: "2024-03-05 14:07:09", id: id, after: true}},
+		{name: "before and after", query: "before=" + cursor + "&after=" + cursor, wantErr: true},
+		{name: "bad cursor", query: "before=nope", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/?"+tt.query, nil)
+			got, err := parsePageQuery(c)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parsePageQuery(%q) = %+v, want error", tt.query, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePageQuery(%q): %v", tt.query, err)
+			}
+			if got != tt.want {
+				t.Errorf("parsePageQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageClause(t *testing.T) {
+	id := uuid.New()
+	tests := []struct {
+		name      string
+		page      pageQuery
+		wantWhere string
+		wantOrder string
+		wantArgs  []any
+	}{
+		{
+			name:      "first page",
+			page:      pageQuery{limit: 20},
+			wantOrder: " ORDER BY created_at DESC, id DESC LIMIT $2",
+			wantArgs:  []any{21},
+		},
+		{
+			name:      "before cursor",
+			page:      pageQuery{limit: 20, createdAt: "2024-03-05 14:07:09", id: id},
+			wantWhere: " AND (created_at, id) < ($2::timestamp, $3)",
+			wantOrder: " ORDER BY created_at DESC, id DESC LIMIT $4",
+			wantArgs:  []any{"2024-03-05 14:07:09", id, 21},
+		},
+		{
+			name:      "after cursor",
+			page:      pageQuery{limit: 20, createdAt: "2024-03-05 14:07:09", id: id, after: true},
+			wantWhere: " AND (created_at, id) > ($2::timestamp, $3)",
+			wantOrder: " ORDER BY created_at ASC, id ASC LIMIT $4",
+			wantArgs:  []any{"2024-03-05 14:07:09", id, 21},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, order, args := tt.page.clause(2)
+			if where != tt.wantWhere || order != tt.wantOrder || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("clause(2) = (%q, %q, %v), want (%q, %q, %v)", where, order, args, tt.wantWhere, tt.wantOrder, tt.wantArgs)
+			}
+		})
+	}
+}
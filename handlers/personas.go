@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+
+	"chat-app/models"
+	"chat-app/workflows"
+
+	"github.com/dbos-inc/dbos-transact-golang/dbos"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// PersonaHandler handles persona template HTTP requests
+type PersonaHandler struct {
+	db        *sql.DB
+	dbosCtx   dbos.DBOSContext
+	workflows *workflows.ChatWorkflows
+}
+
+// NewPersonaHandler creates a new persona handler
+func NewPersonaHandler(db *sql.DB, dbosCtx dbos.DBOSContext, wf *workflows.ChatWorkflows) *PersonaHandler {
+	return &PersonaHandler{
+		db:        db,
+		dbosCtx:   dbosCtx,
+		workflows: wf,
+	}
+}
+
+// CreatePersona creates a new persona using DBOS workflow
+func (h *PersonaHandler) CreatePersona(c *gin.Context) {
+	var req models.PersonaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	input := workflows.CreatePersonaInput{OwnerID: currentUserID(c), Persona: req}
+	handle, err := dbos.RunWorkflow(h.dbosCtx, h.workflows.CreatePersonaWorkflow, input)
+	if err != nil {
+		log.Printf("Failed to start CreatePersona workflow: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create persona"})
+		return
+	}
+
+	persona, err := handle.GetResult()
+	if err != nil {
+		log.Printf("CreatePersona workflow failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create persona"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, persona)
+}
+
+// ListPersonas lists the caller's personas
+func (h *PersonaHandler) ListPersonas(c *gin.Context) {
+	rows, err := h.db.QueryContext(c.Request.Context(),
+		"SELECT "+models.PersonaColumns+" FROM personas WHERE owner_id = $1 ORDER BY name ASC",
+		currentUserID(c))
+	if err != nil {
+		log.Printf("Database error listing personas: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list personas"})
+		return
+	}
+	defer rows.Close()
+
+	personas := []models.Persona{}
+	for rows.Next() {
+		persona, err := models.ScanPersona(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan persona"})
+			return
+		}
+		personas = append(personas, persona)
+	}
+
+	c.JSON(http.StatusOK, personas)
+}
+
+// GetPersona retrieves a persona by ID
+func (h *PersonaHandler) GetPersona(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid persona ID"})
+		return
+	}
+
+	persona, err := models.ScanPersona(h.db.QueryRowContext(c.Request.Context(),
+		"SELECT "+models.PersonaColumns+" FROM personas WHERE id = $1 AND owner_id = $2",
+		id, currentUserID(c)))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Persona not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, persona)
+}
+
+// UpdatePersona replaces a persona using DBOS workflow
+func (h *PersonaHandler) UpdatePersona(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid persona ID"})
+		return
+	}
+
+	var req models.PersonaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	if !personaExists(c.Request.Context(), h.db, currentUserID(c), id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Persona not found"})
+		return
+	}
+
+	input := workflows.UpdatePersonaInput{OwnerID: currentUserID(c), PersonaID: id, Persona: req}
+	handle, err := dbos.RunWorkflow(h.dbosCtx, h.workflows.UpdatePersonaWorkflow, input)
+	if err != nil {
+		log.Printf("Failed to start UpdatePersona workflow: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update persona"})
+		return
+	}
+
+	persona, err := handle.GetResult()
+	if err != nil {
+		log.Printf("UpdatePersona workflow failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update persona"})
+		return
+	}
+
+	c.JSON(http.StatusOK, persona)
+}
+
+// DeletePersona deletes a persona using DBOS workflow
+func (h *PersonaHandler) DeletePersona(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid persona ID"})
+		return
+	}
+
+	input := workflows.DeletePersonaInput{OwnerID: currentUserID(c), PersonaID: id}
+	handle, err := dbos.RunWorkflow(h.dbosCtx, h.workflows.DeletePersonaWorkflow, input)
+	if err != nil {
+		log.Printf("Failed to start DeletePersona workflow: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete persona"})
+		return
+	}
+
+	deleted, err := handle.GetResult()
+	if err != nil {
+		log.Printf("DeletePersona workflow failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete persona"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Persona not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Persona deleted"})
+}
+
+// personaExists reports whether a persona with the given ID exists and belongs to the owner
+func personaExists(ctx context.Context, db *sql.DB, ownerID, id uuid.UUID) bool {
+	var exists bool
+	err := db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM personas WHERE id = $1 AND owner_id = $2)", id, ownerID).Scan(&exists)
+	return err == nil && exists
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"chat-app/workflows"
+
+	"github.com/dbos-inc/dbos-transact-golang/dbos"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RateLimit admits a request only if the caller, and the API key they used, are
+// within their requests-per-minute and tokens-per-day limits. A retry whose
+// Idempotency-Key already started a workflow is let through without counting again,
+// since it only attaches to that workflow. It must run after RequireAuth.
+func RateLimit(dbosCtx dbos.DBOSContext, quotas *workflows.Quotas) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if keyedRetry(c, dbosCtx) {
+			c.Next()
+			return
+		}
+		if err := quotas.AdmitRequest(c.Request.Context(), currentCaller(c)); err != nil {
+			if quotaExceeded(c, err) {
+				return
+			}
+			log.Printf("Failed to check rate limits: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check rate limits"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// keyedRetry reports whether the request carries an Idempotency-Key under which an
+// earlier request on the same conversation already started a workflow. A stored key
+// alone is not enough: it is recorded before the workflow starts, which may then fail.
+func keyedRetry(c *gin.Context, dbosCtx dbos.DBOSContext) bool {
+	key := c.GetHeader("Idempotency-Key")
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	conversationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return false
+	}
+	statuses, err := dbos.ListWorkflows(dbosCtx,
+		dbos.WithWorkflowIDs([]string{keyedWorkflowID(currentUserID(c), conversationID, key)}),
+		dbos.WithUser(currentUserID(c).String()),
+		dbos.WithLoadInput(false),
+		dbos.WithLoadOutput(false))
+	return err == nil && len(statuses) > 0
+}
+
+// currentCaller identifies the authenticated user and API key for quota accounting
+func currentCaller(c *gin.Context) workflows.Caller {
+	caller := workflows.Caller{UserID: currentUserID(c)}
+	if id, ok := c.Get(apiKeyIDKey); ok {
+		if keyID, ok := id.(uuid.UUID); ok {
+			caller.APIKeyID = &keyID
+		}
+	}
+	return caller
+}
+
+// quotaExceeded answers 429 with Retry-After and returns true if err is a QuotaError
+func quotaExceeded(c *gin.Context, err error) bool {
+	var quotaErr *workflows.QuotaError
+	if !errors.As(err, &quotaErr) {
+		return false
+	}
+	rateLimited(c, quotaErr)
+	return true
+}
+
+// workflowQuotaExceeded is quotaExceeded for the error of a reply workflow. When the
+// workflow ran before, e.g. a keyed retry attached to it, its error is only a message,
+// so whether it failed on a limit is read from the workflow's quota step.
+func workflowQuotaExceeded(c *gin.Context, dbosCtx dbos.DBOSContext, workflowID string, err error) bool {
+	if quotaExceeded(c, err) {
+		return true
+	}
+	quotaErr, lookupErr := workflows.QuotaFailure(dbosCtx, workflowID)
+	if lookupErr != nil {
+		log.Printf("Failed to check workflow for a rate limit: %v", lookupErr)
+		return false
+	}
+	if quotaErr == nil {
+		return false
+	}
+	rateLimited(c, quotaErr)
+	return true
+}
+
+// rateLimited answers 429 with Retry-After for an exceeded limit
+func rateLimited(c *gin.Context, quotaErr *workflows.QuotaError) {
+	c.Header("Retry-After", strconv.Itoa(quotaErr.RetryAfterSeconds()))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded: " + quotaErr.Limit})
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"chat-app/workflows"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestQuotaExceeded(t *testing.T) {
+	quotaErr := &workflows.QuotaError{Limit: "10 requests per minute for this user", RetryAfter: 30 * time.Second}
+	tests := []struct {
+		name       string
+		err        error
+		want       bool
+		wantHeader string
+	}{
+		{"quota error", quotaErr, true, "30"},
+		{"wrapped", fmt.Errorf("step failed: %w", quotaErr), true, "30"},
+		{"provider error with the same wording", errors.New("openai: status 429: " + quotaErr.Error()), false, ""},
+		{"message only", errors.New(quotaErr.Error()), false, ""},
+		{"nil", nil, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			if got := quotaExceeded(c, tt.err); got != tt.want {
+				t.Fatalf("quotaExceeded = %v, want %v", got, tt.want)
+			}
+			if !tt.want {
+				return
+			}
+			if w.Code != http.StatusTooManyRequests {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantHeader {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantHeader)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+
+	"chat-app/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultSearchLimit = 20
+
+// ts_headline wraps matches in these private-use characters, which are stripped from
+// the message text first, so highlightSnippet can escape the text and only then add
+// the <mark> tags
+const (
+	matchStart = "\uE000"
+	matchStop  = "\uE001"
+)
+
+// searchHeadlineOptions controls ts_headline snippets
+const searchHeadlineOptions = "StartSel=" + matchStart + ", StopSel=" + matchStop +
+	", MaxWords=35, MinWords=15, MaxFragments=2, FragmentDelimiter=\" … \""
+
+// SearchHandler handles full-text search HTTP requests
+type SearchHandler struct {
+	db *sql.DB
+}
+
+// NewSearchHandler creates a new search handler
+func NewSearchHandler(db *sql.DB) *SearchHandler {
+	return &SearchHandler{db: db}
+}
+
+// Search finds the caller's messages matching a web-style query (quoted phrases,
+// "or", -exclusions), best matches first
+func (h *SearchHandler) Search(c *gin.Context) {
+	var query models.SearchQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid search query: " + err.Error()})
+		return
+	}
+	if strings.TrimSpace(query.Q) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is empty"})
+		return
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultSearchLimit
+	}
+
+	args := []any{currentUserID(c), query.Q}
+	var where strings.Builder
+	filter := func(cond string, arg any) {
+		args = append(args, arg)
+		fmt.Fprintf(&where, " AND "+cond, len(args))
+	}
+	if query.Role != "" {
+		filter("m.role = $%d", query.Role)
+	}
+	if !query.From.IsZero() {
+		filter("m.created_at >= $%d", query.From)
+	}
+	if !query.To.IsZero() {
+		filter("m.created_at < $%d", query.To.AddDate(0, 0, 1))
+	}
+	args = append(args, matchStart+matchStop, searchHeadlineOptions, query.Limit+1, query.Offset)
+	n := len(args)
+
+	rows, err := h.db.QueryContext(c.Request.Context(), fmt.Sprintf(`
+		SELECT m.id, m.conversation_id, c.title, m.role,
+		       ts_headline('english', translate(m.content, $%d, ''), q, $%d),
+		       ts_rank(m.content_tsv, q) AS rank, m.created_at
+		FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id,
+		     websearch_to_tsquery('english', $2) q
+		WHERE c.owner_id = $1 AND m.content_tsv @@ q%s
+		ORDER BY rank DESC, m.created_at DESC
+		LIMIT $%d OFFSET $%d`, n-3, n-2, where.String(), n-1, n),
+		args...)
+	if err != nil {
+		log.Printf("Database error searching messages: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search messages"})
+		return
+	}
+	defer rows.Close()
+
+	results := []models.SearchResult{}
+	for rows.Next() {
+		var r models.SearchResult
+		if err := rows.Scan(&r.MessageID, &r.ConversationID, &r.ConversationTitle, &r.Role,
+			&r.Snippet, &r.Rank, &r.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan search result"})
+			return
+		}
+		r.Snippet = highlightSnippet(r.Snippet)
+		results = append(results, r)
+	}
+
+	page := models.Page[models.SearchResult]{Data: results}
+	if len(results) > query.Limit {
+		page.Data = results[:query.Limit]
+		page.HasMore = true
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// highlightSnippet HTML-escapes a ts_headline snippet and wraps its matches in <mark>
+// tags, so clients can render it as HTML whatever the message contains
+func highlightSnippet(headline string) string {
+	return strings.NewReplacer(matchStart, "<mark>", matchStop, "</mark>").Replace(html.EscapeString(headline))
+}
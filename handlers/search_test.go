@@ -0,0 +1,27 @@
+package handlers
+
+import "testing"
+
+func TestHighlightSnippet(t *testing.T) {
+	tests := []struct {
+		name     string
+		headline string
+		want     string
+	}{
+		{"plain text", "no matches here", "no matches here"},
+		{"matches", "the " + matchStart + "quick" + matchStop + " fox", "the <mark>quick</mark> fox"},
+		{
+			"markup in the message",
+			"<script>alert(1)</script> " + matchStart + "hello" + matchStop,
+			"&lt;script&gt;alert(1)&lt;/script&gt; <mark>hello</mark>",
+		},
+		{"literal mark tags", "<mark>fake</mark> & co", "&lt;mark&gt;fake&lt;/mark&gt; &amp; co"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlightSnippet(tt.headline); got != tt.want {
+				t.Errorf("highlightSnippet = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"chat-app/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// usageGroupColumns maps each group_by value to the expression it groups on
+var usageGroupColumns = map[string]string{
+	"conversation": "u.conversation_id",
+	"model":        "u.model",
+	"day":          "to_char(u.created_at, 'YYYY-MM-DD')",
+}
+
+// UsageHandler handles token usage HTTP requests
+type UsageHandler struct {
+	db *sql.DB
+}
+
+// NewUsageHandler creates a new usage handler
+func NewUsageHandler(db *sql.DB) *UsageHandler {
+	return &UsageHandler{db: db}
+}
+
+// GetUsage reports the tokens the caller's model calls consumed, in total and grouped
+// by conversation, model and/or day. It reads the usage ledger, so replies, titles,
+// summaries and completions all count, including those of deleted conversations.
+func (h *UsageHandler) GetUsage(c *gin.Context) {
+	var query models.UsageQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid usage query: " + err.Error()})
+		return
+	}
+	if query.GroupBy == "" {
+		query.GroupBy = "day"
+	}
+
+	var groups []string
+	seen := make(map[string]bool)
+	for _, g := range strings.Split(query.GroupBy, ",") {
+		g = strings.TrimSpace(g)
+		if _, ok := usageGroupColumns[g]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid group_by %q: use conversation, model or day", g)})
+			return
+		}
+		if !seen[g] {
+			seen[g] = true
+			groups = append(groups, g)
+		}
+	}
+
+	args := []any{currentUserID(c)}
+	var where strings.Builder
+	filter := func(cond string, arg any) {
+		args = append(args, arg)
+		fmt.Fprintf(&where, " AND "+cond, len(args))
+	}
+	if !query.From.IsZero() {
+		filter("u.created_at >= $%d", query.From)
+	}
+	if !query.To.IsZero() {
+		filter("u.created_at < $%d", query.To.AddDate(0, 0, 1))
+	}
+
+	columns := make([]string, len(groups))
+	for i, g := range groups {
+		columns[i] = usageGroupColumns[g]
+	}
+	groupBy := strings.Join(columns, ", ")
+
+	rows, err := h.db.QueryContext(c.Request.Context(), fmt.Sprintf(`
+		SELECT %s, SUM(u.prompt_tokens), SUM(u.completion_tokens), SUM(u.tokens), COUNT(*)
+		FROM usage_ledger u
+		WHERE u.user_id = $1 AND u.requests = 0%s
+		GROUP BY %s
+		ORDER BY %s`, groupBy, where.String(), groupBy, groupBy),
+		args...)
+	if err != nil {
+		log.Printf("Database error aggregating usage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+		return
+	}
+	defer rows.Close()
+
+	report := models.UsageReport{UserID: currentUserID(c), Groups: []models.UsageGroup{}}
+	for rows.Next() {
+		var g models.UsageGroup
+		dest := make([]any, 0, len(groups)+4)
+		for _, name := range groups {
+			switch name {
+			case "conversation":
+				dest = append(dest, &g.ConversationID)
+			case "model":
+				dest = append(dest, &g.Model)
+			case "day":
+				dest = append(dest, &g.Day)
+			}
+		}
+		dest = append(dest, &g.PromptTokens, &g.CompletionTokens, &g.TotalTokens, &g.Calls)
+		if err := rows.Scan(dest...); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan usage"})
+			return
+		}
+		report.Groups = append(report.Groups, g)
+
+		report.Total.PromptTokens += g.PromptTokens
+		report.Total.CompletionTokens += g.CompletionTokens
+		report.Total.TotalTokens += g.TotalTokens
+		report.Total.Calls += g.Calls
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"chat-app/models"
+	"chat-app/workflows"
+
+	"github.com/dbos-inc/dbos-transact-golang/dbos"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header
+const maxIdempotencyKeyLength = 255
+
+// messageWorkflowID picks the workflow ID for a message send and writes an error
+// response when it cannot. A request carrying an Idempotency-Key gets an ID derived
+// from the caller, the conversation and the key, so a retry attaches to the workflow
+// the first attempt started instead of sending the message again. The first request
+// stores a hash of its method, path and body under that ID; a later request with the
+// same key but a different hash is rejected with 422 rather than handed the first
+// request's reply.
+func (h *ChatHandler) messageWorkflowID(c *gin.Context, conversationID uuid.UUID, body any) (string, bool) {
+	key := c.GetHeader("Idempotency-Key")
+	if key == "" {
+		return uuid.NewString(), true
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength)})
+		return "", false
+	}
+	workflowID := keyedWorkflowID(currentUserID(c), conversationID, key)
+
+	hash, err := requestHash(c, body)
+	if err != nil {
+		log.Printf("Failed to hash request body: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		return "", false
+	}
+	// On conflict the no-op update makes RETURNING yield the stored hash
+	var stored string
+	err = h.db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO idempotency_keys (workflow_id, user_id, request_hash)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (workflow_id) DO UPDATE SET workflow_id = EXCLUDED.workflow_id
+		RETURNING request_hash`,
+		workflowID, currentUserID(c), hash).Scan(&stored)
+	if err != nil {
+		log.Printf("Failed to record Idempotency-Key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		return "", false
+	}
+	if stored != hash {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request"})
+		return "", false
+	}
+	return workflowID, true
+}
+
+// trackReply records workflowID as the workflow producing the latest reply in a
+// conversation, so CancelReply can find it from any instance, even before it reaches
+// the model. It writes an error response and returns false if that fails.
+func (h *ChatHandler) trackReply(c *gin.Context, conversationID uuid.UUID, workflowID string) bool {
+	_, err := h.db.ExecContext(c.Request.Context(),
+		"UPDATE conversations SET reply_workflow_id = $1 WHERE id = $2", workflowID, conversationID)
+	if err != nil {
+		log.Printf("Failed to record reply workflow: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		return false
+	}
+	return true
+}
+
+// keyedWorkflowID derives the workflow ID an Idempotency-Key maps to
+func keyedWorkflowID(userID, conversationID uuid.UUID, key string) string {
+	return uuid.NewSHA1(userID, []byte(conversationID.String()+":"+key)).String()
+}
+
+// requestHash fingerprints a request by its method, path and bound body, so the
+// same key sent to a different endpoint or message also counts as a different request
+func requestHash(c *gin.Context, body any) (string, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.New()
+	sum.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+	sum.Write(data)
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// messageWorkflowOptions starts a workflow that asks the model for a reply under
+// the given ID and records the caller as its owner, so its status can be fetched later
+func messageWorkflowOptions(c *gin.Context, workflowID string) []dbos.WorkflowOption {
+	return []dbos.WorkflowOption{
+		dbos.WithWorkflowID(workflowID),
+		dbos.WithAuthenticatedUser(currentUserID(c).String()),
+	}
+}
+
+// asyncRequested reports whether the client asked not to wait for the reply
+func asyncRequested(c *gin.Context) bool {
+	if async, err := strconv.ParseBool(c.Query("async")); err == nil {
+		return async
+	}
+	for _, pref := range strings.Split(c.GetHeader("Prefer"), ",") {
+		if strings.EqualFold(strings.TrimSpace(pref), "respond-async") {
+			return true
+		}
+	}
+	return false
+}
+
+// GetWorkflow reports the status of a message send, edit or regenerate and, once it
+// has succeeded, the saved user and assistant messages. Clients use it to pick up the
+// reply of an async send or of a request whose connection dropped. Other workflows,
+// such as OpenAI-compatible completions, are not served here.
+func (h *ChatHandler) GetWorkflow(c *gin.Context) {
+	workflowID := c.Param("id")
+	if _, err := uuid.Parse(workflowID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workflow ID"})
+		return
+	}
+
+	statuses, err := dbos.ListWorkflows(h.dbosCtx,
+		dbos.WithWorkflowIDs([]string{workflowID}),
+		dbos.WithUser(currentUserID(c).String()),
+		dbos.WithLoadInput(false),
+		dbos.WithLoadOutput(false))
+	if err != nil {
+		log.Printf("Failed to get workflow status: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get workflow status"})
+		return
+	}
+	if len(statuses) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+		return
+	}
+
+	status := statuses[0]
+	if status.Name != workflowName(h.workflows.SendMessageWorkflow) && status.Name != workflowName(h.workflows.RegenerateWorkflow) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+		return
+	}
+
+	result := models.WorkflowStatus{WorkflowID: workflowID}
+	switch status.Status {
+	case dbos.WorkflowStatusPending, dbos.WorkflowStatusEnqueued:
+		result.Status = models.WorkflowPending
+	case dbos.WorkflowStatusCancelled:
+		result.Status = models.WorkflowCancelled
+	case dbos.WorkflowStatusSuccess:
+		reply, err := h.workflowReply(c.Request.Context(), status.Name, workflowID)
+		if err != nil {
+			log.Printf("Failed to get workflow result: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get workflow result"})
+			return
+		}
+		result.Status = models.WorkflowSuccess
+		result.Result = &reply
+	default:
+		result.Status = models.WorkflowError
+		quotaErr, err := workflows.QuotaFailure(h.dbosCtx, workflowID)
+		if err != nil {
+			log.Printf("Failed to check workflow for a rate limit: %v", err)
+		}
+		if quotaErr != nil {
+			result.Error = "Rate limit exceeded: " + quotaErr.Limit
+			result.RetryAfter = quotaErr.RetryAfterSeconds()
+		} else if status.Error != nil {
+			result.Error = "Failed to get AI response: " + status.Error.Error()
+		} else {
+			result.Error = "Workflow " + strings.ToLower(string(status.Status))
+		}
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// workflowReply decodes the output of a succeeded reply workflow. A regenerate
+// workflow only returns the new reply, so the prompt it answers is loaded as well.
+func (h *ChatHandler) workflowReply(ctx context.Context, name, workflowID string) (models.ChatResponse, error) {
+	if name == workflowName(h.workflows.RegenerateWorkflow) {
+		handle, err := dbos.RetrieveWorkflow[models.Message](h.dbosCtx, workflowID)
+		if err != nil {
+			return models.ChatResponse{}, err
+		}
+		reply, err := handle.GetResult()
+		if err != nil {
+			return models.ChatResponse{}, err
+		}
+		// The prompt is the nearest user message above the reply; tool results may sit between them
+		prompt, err := models.ScanMessage(h.db.QueryRowContext(ctx,
+			models.BranchCTE+"SELECT "+models.MessageColumns+" FROM messages JOIN branch ON id = msg_id WHERE role = 'user' ORDER BY depth LIMIT 1",
+			reply.ID))
+		if err != nil {
+			return models.ChatResponse{}, err
+		}
+		return models.ChatResponse{UserMessage: prompt, AssistantMessage: reply}, nil
+	}
+
+	handle, err := dbos.RetrieveWorkflow[workflows.SendMessageOutput](h.dbosCtx, workflowID)
+	if err != nil {
+		return models.ChatResponse{}, err
+	}
+	output, err := handle.GetResult()
+	if err != nil {
+		return models.ChatResponse{}, err
+	}
+	return models.ChatResponse{UserMessage: output.UserMessage, AssistantMessage: output.AssistantMessage}, nil
+}
+
+// workflowName is the name DBOS records for a workflow function registered
+// without a custom name
+func workflowName(fn any) string {
+	return runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"chat-app/workflows"
+)
+
+func TestWorkflowName(t *testing.T) {
+	var a, b *workflows.ChatWorkflows = nil, &workflows.ChatWorkflows{}
+	send := workflowName(a.SendMessageWorkflow)
+	if send != workflowName(b.SendMessageWorkflow) {
+		t.Errorf("name depends on the receiver: %q vs %q", send, workflowName(b.SendMessageWorkflow))
+	}
+	if !strings.Contains(send, "SendMessageWorkflow") {
+		t.Errorf("workflowName = %q, want it to name SendMessageWorkflow", send)
+	}
+	if send == workflowName(a.RegenerateWorkflow) || send == workflowName(a.CompletionWorkflow) {
+		t.Errorf("different workflows share the name %q", send)
+	}
+}
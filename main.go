@@ -37,16 +37,61 @@ func main() {
 	}
 	log.Println("Connected to PostgreSQL database")
 
-	// Initialize vLLM service
-	vllmURL := os.Getenv("VLLM_BASE_URL")
-	if vllmURL == "" {
-		vllmURL = "http://localhost:5000"
-		log.Printf("VLLM_BASE_URL not set, using default: %s", vllmURL)
+	// Initialize the LLM provider selected by LLM_PROVIDER / LLM_CONFIG_FILE
+	providerCfg, err := services.LoadProviderConfig()
+	if err != nil {
+		log.Fatalf("Failed to load LLM provider config: %v", err)
+	}
+	provider, err := services.NewProvider(providerCfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize LLM provider: %v", err)
+	}
+	log.Printf("Using LLM provider: %s", provider.Name())
+	for _, fallback := range providerCfg.Fallbacks {
+		log.Printf("Falling back to LLM provider: %s", fallback.Name)
+	}
+
+	// Models the provider serves, listed at most once per MODELS_CACHE_TTL
+	modelsTTL := 5 * time.Minute
+	if v := os.Getenv("MODELS_CACHE_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid MODELS_CACHE_TTL %q: %v", v, err)
+		}
+		modelsTTL = ttl
+	}
+	catalog := services.NewModelCatalog(provider, modelsTTL)
+
+	// Context window budgeting for conversation history
+	contextBuilder := services.NewContextBuilder(services.LoadContextConfig(), provider, catalog)
+
+	// Embeddings for document retrieval; disabled unless EMBEDDING_MODEL is set
+	embedder := services.NewEmbeddingService(services.LoadEmbeddingConfig())
+	if embedder == nil {
+		log.Println("EMBEDDING_MODEL not set - document ingestion and retrieval disabled")
+	}
+
+	// Tools the model may call, selected by TOOLS
+	toolbox, err := services.LoadToolbox()
+	if err != nil {
+		log.Fatalf("Failed to load tools: %v", err)
+	}
+	if _, ok := provider.(services.ToolCallingProvider); ok && len(toolbox.Specs()) > 0 {
+		log.Printf("Tool calling enabled: %d tools", len(toolbox.Specs()))
 	}
-	vllmService := services.NewVLLMService(vllmURL)
+
+	// Per-user and per-API-key rate limits, selected by RATE_LIMIT_*
+	rateLimits, err := workflows.LoadRateLimits()
+	if err != nil {
+		log.Fatalf("Failed to load rate limits: %v", err)
+	}
+	quotas := workflows.NewQuotas(db, rateLimits)
+
+	// Retries of failed model requests, selected by LLM_RETRY_*
+	retryPolicy := services.LoadRetryPolicy()
 
 	// Initialize workflows
-	chatWorkflows := workflows.NewChatWorkflows(db, vllmService)
+	chatWorkflows := workflows.NewChatWorkflows(db, provider, contextBuilder, embedder, toolbox, quotas, retryPolicy)
 
 	// Initialize DBOS context for durable workflows
 	dbosCtx, err := dbos.NewDBOSContext(context.Background(), dbos.Config{
@@ -59,8 +104,18 @@ func main() {
 
 	// Register workflows with DBOS (MUST be before Launch)
 	dbos.RegisterWorkflow(dbosCtx, chatWorkflows.SendMessageWorkflow)
+	dbos.RegisterWorkflow(dbosCtx, chatWorkflows.GenerateTitleWorkflow)
 	dbos.RegisterWorkflow(dbosCtx, chatWorkflows.CreateConversationWorkflow)
+	dbos.RegisterWorkflow(dbosCtx, chatWorkflows.UpdateConversationWorkflow)
 	dbos.RegisterWorkflow(dbosCtx, chatWorkflows.DeleteConversationWorkflow)
+	dbos.RegisterWorkflow(dbosCtx, chatWorkflows.SwitchBranchWorkflow)
+	dbos.RegisterWorkflow(dbosCtx, chatWorkflows.RegenerateWorkflow)
+	dbos.RegisterWorkflow(dbosCtx, chatWorkflows.CompletionWorkflow)
+	dbos.RegisterWorkflow(dbosCtx, chatWorkflows.CreatePersonaWorkflow)
+	dbos.RegisterWorkflow(dbosCtx, chatWorkflows.UpdatePersonaWorkflow)
+	dbos.RegisterWorkflow(dbosCtx, chatWorkflows.DeletePersonaWorkflow)
+	dbos.RegisterWorkflow(dbosCtx, chatWorkflows.IngestDocumentWorkflow)
+	dbos.RegisterWorkflow(dbosCtx, chatWorkflows.DeleteDocumentWorkflow)
 
 	// Launch DBOS (starts workflow recovery)
 	if err := dbos.Launch(dbosCtx); err != nil {
@@ -69,8 +124,25 @@ func main() {
 	defer dbos.Shutdown(dbosCtx, 5*time.Second)
 	log.Println("DBOS initialized - durable workflows enabled")
 
+	// Session lifetime for bearer tokens issued at login
+	sessionTTL := 30 * 24 * time.Hour
+	if v := os.Getenv("SESSION_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid SESSION_TTL %q: %v", v, err)
+		}
+		sessionTTL = ttl
+	}
+
 	// Initialize handlers
-	chatHandler := handlers.NewChatHandler(db, vllmService, dbosCtx, chatWorkflows)
+	authHandler := handlers.NewAuthHandler(db, sessionTTL)
+	chatHandler := handlers.NewChatHandler(db, provider, dbosCtx, chatWorkflows)
+	personaHandler := handlers.NewPersonaHandler(db, dbosCtx, chatWorkflows)
+	searchHandler := handlers.NewSearchHandler(db)
+	usageHandler := handlers.NewUsageHandler(db)
+	modelHandler := handlers.NewModelHandler(catalog)
+	openAIHandler := handlers.NewOpenAIHandler(dbosCtx, chatWorkflows, catalog, os.Getenv("OPENAI_LOG_CONVERSATIONS") == "true")
+	documentHandler := handlers.NewDocumentHandler(db, dbosCtx, chatWorkflows)
 
 	// Setup Gin router
 	router := gin.Default()
@@ -78,8 +150,8 @@ func main() {
 	// Enable CORS for local development
 	router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, Idempotency-Key, Prefer, X-Log-Conversation")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
 			return
@@ -87,23 +159,84 @@ func main() {
 		c.Next()
 	})
 
-	// API routes
-	api := router.Group("/api")
+	// Account routes (public)
+	auth := router.Group("/api/auth")
+	{
+		auth.POST("/register", authHandler.Register)
+		auth.POST("/login", authHandler.Login)
+		auth.POST("/logout", authHandler.RequireAuth(), authHandler.Logout)
+		auth.GET("/me", authHandler.RequireAuth(), authHandler.Me)
+	}
+
+	// Requests that ask the model for a reply count against the caller's rate limits
+	rateLimit := handlers.RateLimit(dbosCtx, quotas)
+
+	// API routes (authenticated)
+	api := router.Group("/api", authHandler.RequireAuth())
 	{
 		// Conversation routes
 		api.POST("/conversations", chatHandler.CreateConversation)
 		api.GET("/conversations", chatHandler.ListConversations)
+		api.GET("/conversations/export", chatHandler.ExportConversations)
 		api.GET("/conversations/:id", chatHandler.GetConversation)
+		api.PATCH("/conversations/:id", chatHandler.UpdateConversation)
 		api.DELETE("/conversations/:id", chatHandler.DeleteConversation)
+		api.GET("/conversations/:id/export", chatHandler.ExportConversation)
 
 		// Message routes
-		api.POST("/conversations/:id/messages", chatHandler.SendMessage)
+		api.POST("/conversations/:id/messages", rateLimit, chatHandler.SendMessage)
+		api.POST("/conversations/:id/messages/stream", rateLimit, chatHandler.StreamMessage)
+		api.POST("/conversations/:id/cancel", chatHandler.CancelReply)
 		api.GET("/conversations/:id/messages", chatHandler.GetMessages)
+		api.POST("/conversations/:id/messages/:messageId/edit", rateLimit, chatHandler.EditMessage)
+		api.POST("/conversations/:id/messages/:messageId/regenerate", rateLimit, chatHandler.RegenerateMessage)
+
+		// Branch routes
+		api.GET("/conversations/:id/branches", chatHandler.ListBranches)
+		api.PUT("/conversations/:id/branch", chatHandler.SwitchBranch)
+
+		// Workflow routes
+		api.GET("/workflows/:id", chatHandler.GetWorkflow)
+
+		// Search routes
+		api.GET("/search", searchHandler.Search)
+
+		// Usage routes
+		api.GET("/usage", usageHandler.GetUsage)
+
+		// Model routes
+		api.GET("/models", modelHandler.ListModels)
+
+		// Document routes
+		api.POST("/documents", documentHandler.UploadDocument)
+		api.GET("/documents", documentHandler.ListDocuments)
+		api.GET("/documents/search", documentHandler.SearchDocuments)
+		api.GET("/documents/:id", documentHandler.GetDocument)
+		api.DELETE("/documents/:id", documentHandler.DeleteDocument)
+
+		// API key routes
+		api.POST("/keys", authHandler.CreateAPIKey)
+		api.GET("/keys", authHandler.ListAPIKeys)
+		api.DELETE("/keys/:id", authHandler.RevokeAPIKey)
+
+		// Persona routes
+		api.POST("/personas", personaHandler.CreatePersona)
+		api.GET("/personas", personaHandler.ListPersonas)
+		api.GET("/personas/:id", personaHandler.GetPersona)
+		api.PUT("/personas/:id", personaHandler.UpdatePersona)
+		api.DELETE("/personas/:id", personaHandler.DeletePersona)
+	}
+
+	// OpenAI-compatible API (authenticated)
+	openAI := router.Group("/v1", authHandler.RequireAuth())
+	{
+		openAI.POST("/chat/completions", rateLimit, openAIHandler.ChatCompletions)
+		openAI.GET("/models", openAIHandler.ListModels)
 	}
 
 	// Health check
 	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "healthy", "dbos": "enabled"})
+		c.JSON(http.StatusOK, gin.H{"status": "healthy", "dbos": "enabled", "provider": provider.Name()})
 	})
 
 	// Serve static files
@@ -0,0 +1,8 @@
+-- Per-conversation model and sampling parameters.
+-- NULL means "use the provider default".
+ALTER TABLE conversations
+    ADD COLUMN model TEXT,
+    ADD COLUMN temperature DOUBLE PRECISION,
+    ADD COLUMN top_p DOUBLE PRECISION,
+    ADD COLUMN max_tokens INTEGER,
+    ADD COLUMN stop_sequences TEXT[];
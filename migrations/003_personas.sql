@@ -0,0 +1,13 @@
+-- Reusable persona templates whose text becomes the system message
+CREATE TABLE personas (
+    id UUID PRIMARY KEY,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    system_prompt TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT now(),
+    updated_at TIMESTAMP DEFAULT now()
+);
+
+ALTER TABLE conversations
+    ADD COLUMN system_prompt TEXT,
+    ADD COLUMN persona_id UUID REFERENCES personas(id) ON DELETE SET NULL;
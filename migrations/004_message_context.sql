@@ -0,0 +1,3 @@
+-- Messages that were sent to the model to produce each assistant reply
+ALTER TABLE messages
+    ADD COLUMN context_message_ids UUID[];
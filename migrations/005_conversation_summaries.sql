@@ -0,0 +1,17 @@
+-- Rolling summaries of conversation history that no longer fits the context window.
+-- Each summary covers every message up to and including end_message_id.
+CREATE TABLE conversation_summaries (
+    id UUID PRIMARY KEY,
+    conversation_id UUID NOT NULL REFERENCES conversations(id),
+    start_message_id UUID NOT NULL,
+    end_message_id UUID NOT NULL,
+    message_count INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT now(),
+    UNIQUE (conversation_id, end_message_id)
+);
+
+CREATE INDEX idx_conversation_summaries_conversation ON conversation_summaries(conversation_id, created_at);
+
+ALTER TABLE messages
+    ADD COLUMN context_summary_id UUID;
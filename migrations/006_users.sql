@@ -0,0 +1,23 @@
+-- User accounts and login sessions
+CREATE TABLE users (
+    id UUID PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT now()
+);
+
+-- Only a SHA-256 hash of each bearer token is stored
+CREATE TABLE sessions (
+    token_hash TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT now(),
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_sessions_user ON sessions(user_id);
+
+-- Conversations created before accounts existed have no owner and are not visible to anyone
+ALTER TABLE conversations
+    ADD COLUMN owner_id UUID REFERENCES users(id);
+
+CREATE INDEX idx_conversations_owner ON conversations(owner_id, created_at);
@@ -0,0 +1,3 @@
+-- Human-readable conversation titles; empty until renamed or generated
+ALTER TABLE conversations
+    ADD COLUMN title TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+-- Support cursor pagination on (created_at, id)
+CREATE INDEX idx_messages_conversation_created ON messages(conversation_id, created_at, id);
+
+DROP INDEX IF EXISTS idx_conversations_owner;
+CREATE INDEX idx_conversations_owner ON conversations(owner_id, created_at, id);
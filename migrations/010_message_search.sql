@@ -0,0 +1,5 @@
+-- Full-text search over message content
+ALTER TABLE messages
+    ADD COLUMN content_tsv TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', content)) STORED;
+
+CREATE INDEX idx_messages_content_tsv ON messages USING GIN (content_tsv);
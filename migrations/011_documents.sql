@@ -0,0 +1,29 @@
+-- Uploaded documents for retrieval-augmented generation
+CREATE TABLE documents (
+    id UUID PRIMARY KEY,
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    title TEXT NOT NULL,
+    filename TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'processing', -- processing, ready or failed
+    error TEXT,
+    chunk_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT now()
+);
+
+CREATE INDEX idx_documents_owner ON documents(owner_id, created_at);
+
+-- Embeddings are stored unit-length so cosine similarity is a dot product.
+-- Plain REAL[] keeps the schema free of extensions; similarity is computed
+-- over the owner's chunks only.
+CREATE TABLE document_chunks (
+    id UUID PRIMARY KEY,
+    document_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    chunk_index INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    embedding REAL[] NOT NULL,
+    UNIQUE (document_id, chunk_index)
+);
+
+-- Documents cited by an assistant reply
+ALTER TABLE messages
+    ADD COLUMN citations JSONB;
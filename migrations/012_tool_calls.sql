@@ -0,0 +1,5 @@
+-- Tool calling: a "tool_call" message lists the calls the model made, each
+-- "tool_result" message answers one of them
+ALTER TABLE messages
+    ADD COLUMN tool_calls JSONB,
+    ADD COLUMN tool_call_id TEXT;
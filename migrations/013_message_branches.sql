@@ -0,0 +1,28 @@
+-- Conversations become trees: each message points at the message it follows.
+-- Editing a message starts a sibling branch; active_leaf_id is the tip of the
+-- branch the conversation continues from. It is not a foreign key so messages
+-- can be deleted before their conversation.
+ALTER TABLE messages
+    ADD COLUMN parent_id UUID REFERENCES messages(id);
+
+CREATE INDEX idx_messages_parent ON messages(parent_id);
+
+ALTER TABLE conversations
+    ADD COLUMN active_leaf_id UUID;
+
+-- Existing conversations are a single branch in creation order
+UPDATE messages m
+SET parent_id = p.prev_id
+FROM (
+    SELECT id, LAG(id) OVER (PARTITION BY conversation_id ORDER BY created_at, id) AS prev_id
+    FROM messages
+) p
+WHERE m.id = p.id;
+
+UPDATE conversations c
+SET active_leaf_id = (
+    SELECT id FROM messages
+    WHERE conversation_id = c.id
+    ORDER BY created_at DESC, id DESC
+    LIMIT 1
+);
@@ -0,0 +1,2 @@
+-- A reply stopped by the user keeps the text produced so far, marked "cancelled"
+ALTER TABLE messages ADD COLUMN status TEXT NOT NULL DEFAULT 'complete';
@@ -0,0 +1,11 @@
+-- What the backend reported for the model turn behind an assistant or tool_call
+-- message. NULL when unknown, e.g. for messages saved before this migration.
+ALTER TABLE messages
+    ADD COLUMN model TEXT,
+    ADD COLUMN finish_reason TEXT,
+    ADD COLUMN prompt_tokens INTEGER,
+    ADD COLUMN completion_tokens INTEGER,
+    ADD COLUMN total_tokens INTEGER;
+
+-- GET /api/usage aggregates per conversation and day
+CREATE INDEX idx_messages_usage ON messages (conversation_id, created_at) WHERE total_tokens IS NOT NULL;
@@ -0,0 +1,13 @@
+-- Rate limit accounting: one row per admitted request (requests = 1) and one per
+-- reply's token count (tokens > 0), charged to the user and the API key used
+CREATE TABLE usage_ledger (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    api_key_id UUID REFERENCES api_keys(id) ON DELETE CASCADE,
+    requests INTEGER NOT NULL DEFAULT 0,
+    tokens INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_usage_ledger_user ON usage_ledger(user_id, created_at);
+CREATE INDEX idx_usage_ledger_api_key ON usage_ledger(api_key_id, created_at) WHERE api_key_id IS NOT NULL;
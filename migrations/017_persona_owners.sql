@@ -0,0 +1,17 @@
+-- Personas are private to the user who created them. Existing personas go to the
+-- one user whose conversations use them; any other has no owner and is not
+-- visible to anyone.
+ALTER TABLE personas
+    ADD COLUMN owner_id UUID REFERENCES users(id) ON DELETE CASCADE;
+
+UPDATE personas p SET owner_id = u.owner_id
+FROM (
+    SELECT persona_id, MIN(owner_id::text)::uuid AS owner_id
+    FROM conversations
+    WHERE persona_id IS NOT NULL AND owner_id IS NOT NULL
+    GROUP BY persona_id
+    HAVING COUNT(DISTINCT owner_id) = 1
+) u
+WHERE p.id = u.persona_id;
+
+CREATE INDEX idx_personas_owner ON personas(owner_id, name);
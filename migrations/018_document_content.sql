@@ -0,0 +1,13 @@
+-- An upload's text is stored with the document until ingestion has split it into
+-- chunks, so the ingestion workflow's input is only the document ID. Chunks are
+-- stored before they are embedded; the embedding is filled in batch by batch.
+ALTER TABLE documents
+    ADD COLUMN content TEXT;
+
+ALTER TABLE document_chunks
+    ALTER COLUMN embedding DROP NOT NULL;
+
+-- Retrieval scores every chunk of the owner's ready documents with a dot product
+-- over REAL[] on each message, so its cost grows linearly with the number of
+-- chunks a user has. That is fine for a few thousand chunks per user; beyond that,
+-- switch the column to pgvector's vector type with an HNSW index.
@@ -0,0 +1,11 @@
+-- A request sent with an Idempotency-Key records a hash of its body under the
+-- workflow ID the key maps to, so a retry can be told apart from a different
+-- request that reuses the key.
+CREATE TABLE idempotency_keys (
+    workflow_id TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    request_hash TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_idempotency_keys_user ON idempotency_keys(user_id);
@@ -0,0 +1,4 @@
+-- The workflow producing a conversation's latest reply is recorded when it is
+-- started, so a cancel request can find and cancel it from any instance
+ALTER TABLE conversations
+    ADD COLUMN reply_workflow_id TEXT;
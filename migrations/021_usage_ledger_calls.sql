@@ -0,0 +1,20 @@
+-- Every model call, including title and summary generation and completions that
+-- are not logged as conversations, records its usage in the ledger: one row per
+-- call with the model and token counts. conversation_id has no foreign key so the
+-- usage outlives a deleted conversation; /api/usage aggregates these rows.
+ALTER TABLE usage_ledger
+    ADD COLUMN conversation_id UUID,
+    ADD COLUMN model TEXT,
+    ADD COLUMN prompt_tokens INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN completion_tokens INTEGER NOT NULL DEFAULT 0;
+
+-- Token rows so far held one charge per reply, and only when a token limit was
+-- configured; replace them with one row per message that recorded its usage
+DELETE FROM usage_ledger WHERE requests = 0;
+
+INSERT INTO usage_ledger (user_id, conversation_id, model, prompt_tokens, completion_tokens, tokens, created_at)
+SELECT c.owner_id, m.conversation_id, m.model, COALESCE(m.prompt_tokens, 0), COALESCE(m.completion_tokens, 0),
+       m.total_tokens, m.created_at
+FROM messages m
+JOIN conversations c ON c.id = m.conversation_id
+WHERE m.total_tokens IS NOT NULL AND c.owner_id IS NOT NULL;
@@ -8,17 +8,249 @@ import (
 
 // Conversation represents a chat conversation
 type Conversation struct {
+	ID           uuid.UUID  `json:"id"`
+	OwnerID      *uuid.UUID `json:"owner_id,omitempty"`
+	Title        string     `json:"title"`
+	CreatedAt    time.Time  `json:"created_at"`
+	SystemPrompt *string    `json:"system_prompt,omitempty"`
+	PersonaID    *uuid.UUID `json:"persona_id,omitempty"`
+	// ActiveLeafID is the last message of the branch new messages continue from
+	ActiveLeafID *uuid.UUID `json:"active_leaf_id,omitempty"`
+	ModelParams
+}
+
+// ModelParams are the per-conversation model and sampling settings.
+// Nil/empty fields fall back to the provider's defaults.
+type ModelParams struct {
+	Model         *string  `json:"model,omitempty"`
+	Temperature   *float64 `json:"temperature,omitempty" binding:"omitempty,gte=0,lte=2"`
+	TopP          *float64 `json:"top_p,omitempty" binding:"omitempty,gt=0,lte=1"`
+	MaxTokens     *int     `json:"max_tokens,omitempty" binding:"omitempty,gt=0"`
+	StopSequences []string `json:"stop,omitempty" binding:"omitempty,max=4"`
+}
+
+// User is a registered account
+type User struct {
 	ID        uuid.UUID `json:"id"`
+	Email     string    `json:"email"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// APIKey is a programmatic credential. The secret itself is only returned once, at creation.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Persona is a reusable system prompt template that conversations can reference
+type Persona struct {
+	ID           uuid.UUID  `json:"id"`
+	OwnerID      *uuid.UUID `json:"owner_id,omitempty"`
+	Name         string     `json:"name"`
+	Description  string     `json:"description"`
+	SystemPrompt string     `json:"system_prompt"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
 // Message represents a message in a conversation
 type Message struct {
 	ID             uuid.UUID `json:"id"`
 	ConversationID uuid.UUID `json:"conversation_id"`
-	Role           string    `json:"role"` // "user" or "assistant"
+	Role           string    `json:"role"` // "user", "assistant", "tool_call" or "tool_result"
 	Content        string    `json:"content"`
 	CreatedAt      time.Time `json:"created_at"`
+	// ContextMessageIDs lists the messages sent to the model to produce an assistant reply
+	ContextMessageIDs []uuid.UUID `json:"context_message_ids,omitempty"`
+	// ContextSummaryID is the summary that stood in for older history, if any
+	ContextSummaryID *uuid.UUID `json:"context_summary_id,omitempty"`
+	// Citations are the document excerpts injected into the prompt for an assistant reply
+	Citations Citations `json:"citations,omitempty"`
+	// ToolCalls are the tools the model asked to run (role "tool_call")
+	ToolCalls ToolCalls `json:"tool_calls,omitempty"`
+	// ToolCallID links a tool result (role "tool_result") to the call it answers
+	ToolCallID *string `json:"tool_call_id,omitempty"`
+	// ParentID is the message this one follows; nil for the first message of a branch
+	ParentID *uuid.UUID `json:"parent_id,omitempty"`
+	// Status is "complete", or "cancelled" for a reply the user stopped part way
+	Status string `json:"status"`
+	// Model, FinishReason and Usage describe the model turn that produced an
+	// assistant or tool_call message, as reported by the backend
+	Model        *string `json:"model,omitempty"`
+	FinishReason *string `json:"finish_reason,omitempty"`
+	Usage        *Usage  `json:"usage,omitempty"`
+	// SiblingIDs lists the alternatives to this message (itself included) when it has any,
+	// oldest first. Only set when listing messages.
+	SiblingIDs []uuid.UUID `json:"sibling_ids,omitempty"`
+}
+
+// Usage is the number of tokens a model turn consumed
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Branch is one path through a conversation tree, identified by its last message
+type Branch struct {
+	LeafID    uuid.UUID `json:"leaf_id"`
+	Length    int       `json:"length"`
+	Preview   string    `json:"preview"`
+	CreatedAt time.Time `json:"created_at"`
+	Active    bool      `json:"active"`
+}
+
+// Message roles. Tool calls and their results sit between a user message and the
+// assistant reply they led to.
+const (
+	RoleUser       = "user"
+	RoleAssistant  = "assistant"
+	RoleToolCall   = "tool_call"
+	RoleToolResult = "tool_result"
+)
+
+// Message statuses
+const (
+	MessageComplete  = "complete"
+	MessageCancelled = "cancelled"
+)
+
+// ToolCall is a model's request to run a tool. Arguments is a JSON object encoded as a string.
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolCalls is stored as a JSONB column
+type ToolCalls []ToolCall
+
+// Citation identifies a document excerpt an assistant reply was grounded on.
+// Index is the [n] marker the model was asked to cite it with.
+type Citation struct {
+	Index         int       `json:"index"`
+	DocumentID    uuid.UUID `json:"document_id"`
+	DocumentTitle string    `json:"document_title"`
+	ChunkID       uuid.UUID `json:"chunk_id"`
+	ChunkIndex    int       `json:"chunk_index"`
+	Snippet       string    `json:"snippet"`
+	Score         float64   `json:"score"`
+}
+
+// Citations is stored as a JSONB column
+type Citations []Citation
+
+// Document is an uploaded text document, split into embedded chunks for retrieval
+type Document struct {
+	ID         uuid.UUID `json:"id"`
+	OwnerID    uuid.UUID `json:"owner_id"`
+	Title      string    `json:"title"`
+	Filename   string    `json:"filename"`
+	Status     string    `json:"status"` // "processing", "ready" or "failed"
+	Error      *string   `json:"error,omitempty"`
+	ChunkCount int       `json:"chunk_count"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// DocumentMatch is a document chunk returned by semantic search
+type DocumentMatch struct {
+	DocumentID    uuid.UUID `json:"document_id"`
+	DocumentTitle string    `json:"document_title"`
+	ChunkID       uuid.UUID `json:"chunk_id"`
+	ChunkIndex    int       `json:"chunk_index"`
+	Content       string    `json:"content"`
+	Score         float64   `json:"score"`
+}
+
+// ConversationSummary is a model-written summary of a conversation's older messages.
+// It covers every message up to and including EndMessageID.
+type ConversationSummary struct {
+	ID             uuid.UUID `json:"id"`
+	ConversationID uuid.UUID `json:"conversation_id"`
+	StartMessageID uuid.UUID `json:"start_message_id"`
+	EndMessageID   uuid.UUID `json:"end_message_id"`
+	MessageCount   int       `json:"message_count"`
+	Content        string    `json:"content"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// CreateConversationRequest is the optional request body for creating a conversation
+type CreateConversationRequest struct {
+	Title        string     `json:"title" binding:"max=200"`
+	SystemPrompt *string    `json:"system_prompt"`
+	PersonaID    *uuid.UUID `json:"persona_id"`
+	ModelParams
+}
+
+// UpdateConversationRequest is the request body for PATCH /api/conversations/:id.
+// Only fields that are present are changed.
+type UpdateConversationRequest struct {
+	Title *string `json:"title" binding:"omitempty,max=200"`
+	// An empty system_prompt or persona_id clears the field
+	SystemPrompt  *string   `json:"system_prompt"`
+	PersonaID     *string   `json:"persona_id" binding:"omitempty,uuid"`
+	Model         *string   `json:"model"`
+	Temperature   *float64  `json:"temperature" binding:"omitempty,gte=0,lte=2"`
+	TopP          *float64  `json:"top_p" binding:"omitempty,gt=0,lte=1"`
+	MaxTokens     *int      `json:"max_tokens" binding:"omitempty,gt=0"`
+	StopSequences *[]string `json:"stop" binding:"omitempty,max=4"`
+}
+
+// PersonaRequest is the request body for creating or replacing a persona
+type PersonaRequest struct {
+	Name         string `json:"name" binding:"required"`
+	Description  string `json:"description"`
+	SystemPrompt string `json:"system_prompt" binding:"required"`
+}
+
+// CredentialsRequest is the request body for registering and logging in
+type CredentialsRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,min=8,max=72"`
+}
+
+// LoginResponse is returned after a successful login or registration
+type LoginResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	User      User      `json:"user"`
+}
+
+// CreateAPIKeyRequest is the request body for creating an API key.
+// Scopes default to read and write.
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" binding:"required"`
+	Scopes []string `json:"scopes" binding:"omitempty,dive,oneof=read write"`
+}
+
+// CreateAPIKeyResponse returns the new key's secret alongside its metadata
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// UploadDocumentRequest is the JSON alternative to a multipart document upload
+type UploadDocumentRequest struct {
+	Title   string `json:"title" binding:"required"`
+	Content string `json:"content" binding:"required"`
+}
+
+// SwitchBranchRequest selects the branch containing MessageID. The conversation
+// continues from the newest message below it.
+type SwitchBranchRequest struct {
+	MessageID uuid.UUID `json:"message_id" binding:"required"`
+}
+
+// RegenerateRequest is the optional request body for regenerating a reply.
+// Unset fields use the conversation's settings.
+type RegenerateRequest struct {
+	Model       *string  `json:"model"`
+	Temperature *float64 `json:"temperature" binding:"omitempty,gte=0,lte=2"`
 }
 
 // SendMessageRequest is the request body for sending a message
@@ -26,8 +258,120 @@ type SendMessageRequest struct {
 	Content string `json:"content" binding:"required"`
 }
 
+// SearchQuery is the query string for full-text message search.
+// From and To are dates (YYYY-MM-DD); To is inclusive.
+type SearchQuery struct {
+	Q      string    `form:"q" binding:"required"`
+	Role   string    `form:"role" binding:"omitempty,oneof=user assistant"`
+	From   time.Time `form:"from" time_format:"2006-01-02"`
+	To     time.Time `form:"to" time_format:"2006-01-02"`
+	Limit  int       `form:"limit" binding:"omitempty,min=1,max=100"`
+	Offset int       `form:"offset" binding:"omitempty,min=0"`
+}
+
+// SearchResult is a message matching a search, with the matching terms highlighted
+type SearchResult struct {
+	MessageID         uuid.UUID `json:"message_id"`
+	ConversationID    uuid.UUID `json:"conversation_id"`
+	ConversationTitle string    `json:"conversation_title"`
+	Role              string    `json:"role"`
+	Snippet           string    `json:"snippet"`
+	Rank              float64   `json:"rank"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// UsageQuery is the query string for token usage reports. GroupBy is a
+// comma-separated list of "conversation", "model" and "day" (default "day").
+// From and To are dates (YYYY-MM-DD); To is inclusive.
+type UsageQuery struct {
+	GroupBy string    `form:"group_by"`
+	From    time.Time `form:"from" time_format:"2006-01-02"`
+	To      time.Time `form:"to" time_format:"2006-01-02"`
+}
+
+// UsageTotals sums the token usage of a set of model calls
+type UsageTotals struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+	Calls            int64 `json:"calls"`
+}
+
+// UsageGroup is the usage of one conversation, model and/or day; only the
+// fields being grouped by are set
+type UsageGroup struct {
+	ConversationID *uuid.UUID `json:"conversation_id,omitempty"`
+	Model          *string    `json:"model,omitempty"`
+	Day            *string    `json:"day,omitempty"`
+	UsageTotals
+}
+
+// UsageReport is a user's token usage, in total and per group
+type UsageReport struct {
+	UserID uuid.UUID    `json:"user_id"`
+	Total  UsageTotals  `json:"total"`
+	Groups []UsageGroup `json:"groups"`
+}
+
+// Page is one page of a cursor-paginated list. NextCursor continues in the same
+// direction: pass it as "before" (or "after" when paging forward).
+type Page[T any] struct {
+	Data       []T    `json:"data"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
 // ChatResponse is the response for a chat message
 type ChatResponse struct {
 	UserMessage      Message `json:"user_message"`
 	AssistantMessage Message `json:"assistant_message"`
 }
+
+// Statuses of an asynchronous message send
+const (
+	WorkflowPending   = "pending"
+	WorkflowSuccess   = "success"
+	WorkflowError     = "error"
+	WorkflowCancelled = "cancelled"
+)
+
+// WorkflowStatus reports the progress of a message send started in async mode.
+// Result is set once the workflow succeeds and Error once it fails.
+type WorkflowStatus struct {
+	WorkflowID string        `json:"workflow_id"`
+	Status     string        `json:"status"`
+	Result     *ChatResponse `json:"result,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	// RetryAfter is set, in seconds, when the workflow failed on a rate limit
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// ModelInfo describes a model one of the configured providers can answer with
+type ModelInfo struct {
+	ID       string `json:"id"`
+	Provider string `json:"provider"`
+	// MaxContextLength is the model's context window in tokens; 0 if unknown
+	MaxContextLength int `json:"max_context_length"`
+	// Default marks the model used when a conversation does not choose one
+	Default bool `json:"default"`
+}
+
+// ConversationExport is a conversation's full message tree, oldest first, each
+// message linked to the one it follows by parent_id. ActiveBranch lists the IDs of
+// the messages on the active branch, from the first to the active leaf.
+type ConversationExport struct {
+	Conversation Conversation `json:"conversation"`
+	ExportedAt   time.Time    `json:"exported_at"`
+	Messages     []Message    `json:"messages"`
+	ActiveBranch []uuid.UUID  `json:"active_branch"`
+}
+
+// ExportRecord is one line of a JSONL export: the conversation first, then one
+// line per message
+type ExportRecord struct {
+	Type         string        `json:"type"` // "conversation" or "message"
+	Conversation *Conversation `json:"conversation,omitempty"`
+	ExportedAt   *time.Time    `json:"exported_at,omitempty"`
+	ActiveBranch []uuid.UUID   `json:"active_branch,omitempty"`
+	Message      *Message      `json:"message,omitempty"`
+}
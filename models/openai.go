@@ -0,0 +1,124 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// OpenAIChatRequest is the request body for the OpenAI-compatible POST /v1/chat/completions
+type OpenAIChatRequest struct {
+	Model       string          `json:"model" binding:"required"`
+	Messages    []OpenAIMessage `json:"messages" binding:"required,min=1,dive"`
+	Temperature *float64        `json:"temperature" binding:"omitempty,gte=0,lte=2"`
+	TopP        *float64        `json:"top_p" binding:"omitempty,gt=0,lte=1"`
+	MaxTokens   *int            `json:"max_tokens" binding:"omitempty,gt=0"`
+	// MaxCompletionTokens is the newer name for MaxTokens and wins if both are set
+	MaxCompletionTokens *int                 `json:"max_completion_tokens" binding:"omitempty,gt=0"`
+	Stop                OpenAIStop           `json:"stop" binding:"max=4"`
+	Stream              bool                 `json:"stream"`
+	StreamOptions       *OpenAIStreamOptions `json:"stream_options"`
+}
+
+// OpenAIStreamOptions configures a stream=true request
+type OpenAIStreamOptions struct {
+	// IncludeUsage asks for a final chunk with the token usage
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// OpenAIMessage is one message of an OpenAI chat completion request. Tool calls
+// and tool results are not supported.
+type OpenAIMessage struct {
+	Role    string        `json:"role" binding:"required,oneof=system developer user assistant"`
+	Content OpenAIContent `json:"content"`
+}
+
+// OpenAIContent is message content, sent either as a string or as an array of
+// content parts. Only text parts are kept; they are joined by newlines.
+type OpenAIContent string
+
+func (c *OpenAIContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = OpenAIContent(text)
+		return nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return fmt.Errorf("content must be a string or an array of content parts")
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Type != "text" {
+			return fmt.Errorf("unsupported content part type %q", part.Type)
+		}
+		texts = append(texts, part.Text)
+	}
+	*c = OpenAIContent(strings.Join(texts, "\n"))
+	return nil
+}
+
+// OpenAIStop is a single stop sequence or a list of them
+type OpenAIStop []string
+
+func (s *OpenAIStop) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*s = OpenAIStop{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("stop must be a string or an array of strings")
+	}
+	*s = list
+	return nil
+}
+
+// OpenAIChatResponse is the response of a non-streaming chat completion
+type OpenAIChatResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []OpenAIChoice `json:"choices"`
+	Usage   *Usage         `json:"usage,omitempty"`
+	// ConversationID is the conversation the exchange was logged to, if it was
+	ConversationID *uuid.UUID `json:"conversation_id,omitempty"`
+}
+
+// OpenAIChoice is the single reply of a chat completion. A streamed chunk carries
+// Delta instead of Message.
+type OpenAIChoice struct {
+	Index        int                 `json:"index"`
+	Message      *OpenAIReplyMessage `json:"message,omitempty"`
+	Delta        *OpenAIReplyMessage `json:"delta,omitempty"`
+	FinishReason *string             `json:"finish_reason"`
+}
+
+// OpenAIReplyMessage is the assistant's reply, or a fragment of it when streaming
+type OpenAIReplyMessage struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content"`
+}
+
+// OpenAIModelList is the response of the OpenAI-compatible GET /v1/models
+type OpenAIModelList struct {
+	Object string        `json:"object"`
+	Data   []OpenAIModel `json:"data"`
+}
+
+// OpenAIModel describes one model in OpenAI's format
+type OpenAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+	// MaxModelLen is the context length, as vLLM reports it; omitted if unknown
+	MaxModelLen int `json:"max_model_len,omitempty"`
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestOpenAIContentUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    OpenAIContent
+		wantErr bool
+	}{
+		{name: "string", data: `"Hello"`, want: "Hello"},
+		{name: "empty string", data: `""`, want: ""},
+		{name: "one text part", data: `[{"type":"text","text":"Hello"}]`, want: "Hello"},
+		{name: "text parts joined by newlines", data: `[{"type":"text","text":"one"},{"type":"text","text":"two"}]`, want: "one\ntwo"},
+		{name: "no parts", data: `[]`, want: ""},
+		{name: "image part", data: `[{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}]`, wantErr: true},
+		{name: "number", data: `42`, wantErr: true},
+		{name: "object", data: `{"text":"Hello"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got OpenAIContent
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Unmarshal(%s) = %q, want error", tt.data, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal(%s): %v", tt.data, err)
+			}
+			if got != tt.want {
+				t.Errorf("Unmarshal(%s) = %q, want %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAIStopUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    OpenAIStop
+		wantErr bool
+	}{
+		{name: "single sequence", data: `"\n\n"`, want: OpenAIStop{"\n\n"}},
+		{name: "list", data: `["END","STOP"]`, want: OpenAIStop{"END", "STOP"}},
+		{name: "empty list", data: `[]`, want: OpenAIStop{}},
+		{name: "number", data: `1`, wantErr: true},
+		{name: "list of numbers", data: `[1,2]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got OpenAIStop
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Unmarshal(%s) = %q, want error", tt.data, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal(%s): %v", tt.data, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal(%s) = %q, want %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAIChatRequestStop(t *testing.T) {
+	var req OpenAIChatRequest
+	data := `{"model":"m","messages":[{"role":"user","content":[{"type":"text","text":"hi"}]}],"stop":"END"}`
+	if err := json.Unmarshal([]byte(data), &req); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if len(req.Messages) != 1 || req.Messages[0].Content != "hi" {
+		t.Errorf("Messages = %+v, want one message with content %q", req.Messages, "hi")
+	}
+	if !reflect.DeepEqual(req.Stop, OpenAIStop{"END"}) {
+		t.Errorf("Stop = %q, want [END]", req.Stop)
+	}
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// RowScanner is satisfied by *sql.Row and *sql.Rows
+type RowScanner interface {
+	Scan(dest ...any) error
+}
+
+// ConversationColumns is the column list ScanConversation expects, in order
+const ConversationColumns = "id, owner_id, title, created_at, system_prompt, persona_id, active_leaf_id, model, temperature, top_p, max_tokens, stop_sequences"
+
+// ScanConversation scans a row selected with ConversationColumns
+func ScanConversation(row RowScanner) (Conversation, error) {
+	var conv Conversation
+	err := row.Scan(&conv.ID, &conv.OwnerID, &conv.Title, &conv.CreatedAt, &conv.SystemPrompt, &conv.PersonaID,
+		&conv.ActiveLeafID, &conv.Model, &conv.Temperature, &conv.TopP, &conv.MaxTokens, pq.Array(&conv.StopSequences))
+	return conv, err
+}
+
+// PersonaColumns is the column list ScanPersona expects, in order
+const PersonaColumns = "id, owner_id, name, description, system_prompt, created_at, updated_at"
+
+// ScanPersona scans a row selected with PersonaColumns
+func ScanPersona(row RowScanner) (Persona, error) {
+	var p Persona
+	err := row.Scan(&p.ID, &p.OwnerID, &p.Name, &p.Description, &p.SystemPrompt, &p.CreatedAt, &p.UpdatedAt)
+	return p, err
+}
+
+// MessageColumns is the column list ScanMessage expects, in order
+const MessageColumns = "id, conversation_id, role, content, created_at, context_message_ids, context_summary_id, " +
+	"citations, tool_calls, tool_call_id, parent_id, status, model, finish_reason, prompt_tokens, completion_tokens, total_tokens"
+
+// ScanMessage scans a row selected with MessageColumns
+func ScanMessage(row RowScanner) (Message, error) {
+	var msg Message
+	var promptTokens, completionTokens, totalTokens *int
+	err := row.Scan(&msg.ID, &msg.ConversationID, &msg.Role, &msg.Content, &msg.CreatedAt,
+		pq.Array(&msg.ContextMessageIDs), &msg.ContextSummaryID, &msg.Citations,
+		&msg.ToolCalls, &msg.ToolCallID, &msg.ParentID, &msg.Status, &msg.Model, &msg.FinishReason,
+		&promptTokens, &completionTokens, &totalTokens)
+	if totalTokens != nil {
+		msg.Usage = &Usage{TotalTokens: *totalTokens}
+		if promptTokens != nil {
+			msg.Usage.PromptTokens = *promptTokens
+		}
+		if completionTokens != nil {
+			msg.Usage.CompletionTokens = *completionTokens
+		}
+	}
+	return msg, err
+}
+
+// BranchCTE walks from leaf message $1 up to the root of its conversation tree,
+// producing branch(msg_id, up_id, depth) with depth 0 for the leaf. Follow it with
+// a SELECT that joins messages ON id = msg_id.
+const BranchCTE = `WITH RECURSIVE branch(msg_id, up_id, depth) AS (
+	SELECT id, parent_id, 0 FROM messages WHERE id = $1
+	UNION ALL
+	SELECT m.id, m.parent_id, b.depth + 1 FROM messages m JOIN branch b ON m.id = b.up_id
+) `
+
+// DocumentColumns is the column list ScanDocument expects, in order
+const DocumentColumns = "id, owner_id, title, filename, status, error, chunk_count, created_at"
+
+// ScanDocument scans a row selected with DocumentColumns
+func ScanDocument(row RowScanner) (Document, error) {
+	var d Document
+	err := row.Scan(&d.ID, &d.OwnerID, &d.Title, &d.Filename, &d.Status, &d.Error, &d.ChunkCount, &d.CreatedAt)
+	return d, err
+}
+
+// Value implements driver.Valuer; no citations are stored as NULL
+func (c Citations) Value() (driver.Value, error) {
+	return jsonValue(len(c), c)
+}
+
+// Scan implements sql.Scanner
+func (c *Citations) Scan(src any) error {
+	return scanJSON(src, c)
+}
+
+// Value implements driver.Valuer; no tool calls are stored as NULL
+func (t ToolCalls) Value() (driver.Value, error) {
+	return jsonValue(len(t), t)
+}
+
+// Scan implements sql.Scanner
+func (t *ToolCalls) Scan(src any) error {
+	return scanJSON(src, t)
+}
+
+// jsonValue encodes v for a JSONB column, or NULL if it has no elements
+func jsonValue(n int, v any) (driver.Value, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+// scanJSON decodes a JSONB column into dst, leaving it zero for NULL
+func scanJSON(src, dst any) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, dst)
+	case string:
+		return json.Unmarshal([]byte(v), dst)
+	default:
+		return fmt.Errorf("cannot scan %T into %T", src, dst)
+	}
+}
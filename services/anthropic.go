@@ -2,114 +2,454 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 
 	"chat-app/models"
 )
 
-const anthropicAPIURL = "https://api.anthropic.com/v1/messages"
+const (
+	anthropicAPIURL       = "https://api.anthropic.com/v1/messages"
+	defaultAnthropicModel = "claude-sonnet-4-20250514"
+)
 
 // AnthropicService handles communication with the Anthropic API
 type AnthropicService struct {
-	apiKey string
-	client *http.Client
+	apiKey       string
+	model        string
+	client       *http.Client
+	streamClient *http.Client
+}
+
+func init() {
+	RegisterProvider("anthropic", func(cfg ProviderConfig) (Provider, error) {
+		apiKey := cfg.Option("api_key", "ANTHROPIC_API_KEY", "")
+		if apiKey == "" {
+			return nil, fmt.Errorf("anthropic provider requires ANTHROPIC_API_KEY")
+		}
+		return NewAnthropicService(apiKey, cfg.Option("model", "ANTHROPIC_MODEL", defaultAnthropicModel)), nil
+	})
 }
 
 // NewAnthropicService creates a new Anthropic service
-func NewAnthropicService(apiKey string) *AnthropicService {
+func NewAnthropicService(apiKey, model string) *AnthropicService {
+	if model == "" {
+		model = defaultAnthropicModel
+	}
 	return &AnthropicService{
 		apiKey: apiKey,
-		client: &http.Client{},
+		model:  model,
+		client: &http.Client{
+			Timeout: 120 * time.Second,
+		},
+		// Streams can outlive the regular timeout; they are bounded by the caller's context
+		streamClient: &http.Client{},
+	}
+}
+
+// Name implements Provider
+func (s *AnthropicService) Name() string {
+	return "anthropic"
+}
+
+// anthropicModels are the models offered by the Anthropic API, which all have a
+// 200K token context window
+var anthropicModels = []string{
+	"claude-opus-4-20250514",
+	"claude-sonnet-4-20250514",
+	"claude-3-7-sonnet-20250219",
+	"claude-3-5-haiku-20241022",
+}
+
+const anthropicContextLength = 200000
+
+// ListModels implements ModelLister with the static model list, plus the
+// configured model if it is not on it
+func (s *AnthropicService) ListModels(ctx context.Context) ([]models.ModelInfo, error) {
+	ids := anthropicModels
+	if !slices.Contains(ids, s.model) {
+		ids = append([]string{s.model}, ids...)
 	}
+	list := make([]models.ModelInfo, len(ids))
+	for i, id := range ids {
+		list[i] = models.ModelInfo{
+			ID:               id,
+			Provider:         s.Name(),
+			MaxContextLength: anthropicContextLength,
+			Default:          id == s.model,
+		}
+	}
+	return list, nil
 }
 
 // AnthropicMessage represents a message in the Anthropic API format
 type AnthropicMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string                  `json:"role"`
+	Content []AnthropicContentBlock `json:"content"`
+}
+
+// AnthropicContentBlock is a text, tool_use or tool_result block
+type AnthropicContentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+// AnthropicTool declares a tool the model may use
+type AnthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
 }
 
 // AnthropicRequest represents a request to the Anthropic API
 type AnthropicRequest struct {
-	Model     string             `json:"model"`
-	MaxTokens int                `json:"max_tokens"`
-	Messages  []AnthropicMessage `json:"messages"`
+	Model         string             `json:"model"`
+	MaxTokens     int                `json:"max_tokens"`
+	System        string             `json:"system,omitempty"`
+	Messages      []AnthropicMessage `json:"messages"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+	Tools         []AnthropicTool    `json:"tools,omitempty"`
 }
 
 // AnthropicResponse represents a response from the Anthropic API
 type AnthropicResponse struct {
-	Content []struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
-	} `json:"content"`
+	Model      string                  `json:"model"`
+	Content    []AnthropicContentBlock `json:"content"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      *AnthropicUsage         `json:"usage"`
+	Error      *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// AnthropicUsage is the token usage of a Messages API response
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// AnthropicStreamEvent is the data payload of a Messages API stream event
+type AnthropicStreamEvent struct {
+	Type  string `json:"type"`
+	Delta struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	// Message is sent with message_start; its usage holds the input tokens
+	Message *struct {
+		Model string          `json:"model"`
+		Usage *AnthropicUsage `json:"usage"`
+	} `json:"message"`
+	// Usage is sent with message_delta and holds the output tokens so far
+	Usage *AnthropicUsage `json:"usage"`
 	Error *struct {
 		Type    string `json:"type"`
 		Message string `json:"message"`
 	} `json:"error"`
 }
 
-// Chat sends a message to Claude and returns the response
-func (s *AnthropicService) Chat(messages []models.Message, userMessage string) (string, error) {
-	// Convert messages to Anthropic format
+// newRequest converts a ChatRequest to an Anthropic request, applying the conversation's parameters
+func (s *AnthropicService) newRequest(chatReq ChatRequest) AnthropicRequest {
+	// Convert history, the new user message, then this turn's tool calls and results.
+	// Consecutive messages with the same role (e.g. several tool results) are merged.
+	withTools := len(chatReq.Tools) > 0
+	calls := make(map[string]bool)
 	var anthropicMessages []AnthropicMessage
-	for _, msg := range messages {
-		anthropicMessages = append(anthropicMessages, AnthropicMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-		})
+	appendBlocks := func(role string, blocks ...AnthropicContentBlock) {
+		if len(blocks) == 0 {
+			return
+		}
+		if n := len(anthropicMessages); n > 0 && anthropicMessages[n-1].Role == role {
+			anthropicMessages[n-1].Content = append(anthropicMessages[n-1].Content, blocks...)
+			return
+		}
+		anthropicMessages = append(anthropicMessages, AnthropicMessage{Role: role, Content: blocks})
+	}
+	appendMessage := func(msg models.Message) {
+		role, blocks := anthropicBlocks(msg, withTools, calls)
+		appendBlocks(role, blocks...)
+	}
+	for _, msg := range chatReq.Messages {
+		appendMessage(msg)
+	}
+	appendBlocks("user", textBlock(chatReq.UserMessage)...)
+	for _, msg := range chatReq.Turn {
+		appendMessage(msg)
 	}
-	// Add the new user message
-	anthropicMessages = append(anthropicMessages, AnthropicMessage{
-		Role:    "user",
-		Content: userMessage,
-	})
 
+	params := chatReq.Params
 	reqBody := AnthropicRequest{
-		Model:     "claude-sonnet-4-20250514",
-		MaxTokens: 4096,
-		Messages:  anthropicMessages,
+		Model:         s.model,
+		MaxTokens:     defaultMaxTokens,
+		System:        chatReq.System,
+		Messages:      anthropicMessages,
+		Temperature:   params.Temperature,
+		TopP:          params.TopP,
+		StopSequences: params.StopSequences,
 	}
+	if params.Model != nil && *params.Model != "" {
+		reqBody.Model = *params.Model
+	}
+	if params.MaxTokens != nil {
+		reqBody.MaxTokens = *params.MaxTokens
+	}
+	for _, spec := range chatReq.Tools {
+		reqBody.Tools = append(reqBody.Tools, AnthropicTool{
+			Name:        spec.Name,
+			Description: spec.Description,
+			InputSchema: spec.Parameters,
+		})
+	}
+	return reqBody
+}
 
+// anthropicBlocks converts a stored message to a role and content blocks. Without
+// tools, tool calls and results become plain text. A tool result whose call is
+// not in the request (e.g. trimmed from the context window) is dropped.
+func anthropicBlocks(msg models.Message, withTools bool, calls map[string]bool) (string, []AnthropicContentBlock) {
+	switch msg.Role {
+	case models.RoleToolCall:
+		if !withTools {
+			return "assistant", textBlock(toolCallText(msg))
+		}
+		blocks := textBlock(msg.Content)
+		for _, call := range msg.ToolCalls {
+			input := json.RawMessage(call.Arguments)
+			if !json.Valid(input) {
+				input = json.RawMessage("{}")
+			}
+			blocks = append(blocks, AnthropicContentBlock{Type: "tool_use", ID: call.ID, Name: call.Name, Input: input})
+			calls[call.ID] = true
+		}
+		return "assistant", blocks
+	case models.RoleToolResult:
+		if !withTools {
+			return "user", textBlock(toolResultText(msg))
+		}
+		if msg.ToolCallID == nil || !calls[*msg.ToolCallID] {
+			return "user", nil
+		}
+		return "user", []AnthropicContentBlock{{Type: "tool_result", ToolUseID: *msg.ToolCallID, Content: msg.Content}}
+	default:
+		return msg.Role, textBlock(msg.Content)
+	}
+}
+
+// textBlock returns a text block, or none for empty text (which the API rejects)
+func textBlock(text string) []AnthropicContentBlock {
+	if text == "" {
+		return nil
+	}
+	return []AnthropicContentBlock{{Type: "text", Text: text}}
+}
+
+// newHTTPRequest builds an authenticated POST to the Messages API
+func (s *AnthropicService) newHTTPRequest(ctx context.Context, reqBody AnthropicRequest) (*http.Request, error) {
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", anthropicAPIURL, bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", anthropicAPIURL, bytes.NewBuffer(jsonBody))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("x-api-key", s.apiKey)
 	req.Header.Set("anthropic-version", "2023-06-01")
+	return req, nil
+}
+
+// Chat sends a message to Claude and returns the response; req.Tools is ignored
+func (s *AnthropicService) Chat(ctx context.Context, chatReq ChatRequest) (ChatResult, error) {
+	chatReq.Tools = nil
+	result, err := s.complete(ctx, s.newRequest(chatReq))
+	if err != nil {
+		return ChatResult{}, err
+	}
+	if result.Content == "" {
+		return ChatResult{}, fmt.Errorf("empty response from Anthropic")
+	}
+	return result, nil
+}
+
+// ChatWithTools implements ToolCallingProvider
+func (s *AnthropicService) ChatWithTools(ctx context.Context, chatReq ChatRequest) (ChatResult, error) {
+	result, err := s.complete(ctx, s.newRequest(chatReq))
+	if err != nil {
+		return ChatResult{}, err
+	}
+	if result.Content == "" && len(result.ToolCalls) == 0 {
+		return ChatResult{}, fmt.Errorf("empty response from Anthropic")
+	}
+	return result, nil
+}
+
+// complete sends a non-streaming Messages API request
+func (s *AnthropicService) complete(ctx context.Context, reqBody AnthropicRequest) (ChatResult, error) {
+	req, err := s.newHTTPRequest(ctx, reqBody)
+	if err != nil {
+		return ChatResult{}, err
+	}
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+		return ChatResult{}, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return ChatResult{}, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	var anthropicResp AnthropicResponse
 	if err := json.Unmarshal(body, &anthropicResp); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+		if resp.StatusCode != http.StatusOK {
+			return ChatResult{}, &StatusError{Backend: "anthropic", StatusCode: resp.StatusCode, Body: string(body)}
+		}
+		return ChatResult{}, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
 	if anthropicResp.Error != nil {
-		return "", fmt.Errorf("anthropic API error: %s", anthropicResp.Error.Message)
+		return ChatResult{}, &StatusError{Backend: "anthropic", StatusCode: resp.StatusCode, Body: anthropicResp.Error.Message}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ChatResult{}, &StatusError{Backend: "anthropic", StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
-	if len(anthropicResp.Content) == 0 {
-		return "", fmt.Errorf("empty response from Anthropic")
+	// Claude may return several content blocks; join all text blocks and collect tool uses
+	result := ChatResult{
+		Model:        anthropicResp.Model,
+		FinishReason: finishReason(anthropicResp.StopReason),
+	}
+	if u := anthropicResp.Usage; u != nil {
+		result.Usage = u.toModel()
+	}
+	for _, block := range anthropicResp.Content {
+		switch block.Type {
+		case "text":
+			result.Content += block.Text
+		case "tool_use":
+			result.ToolCalls = append(result.ToolCalls, models.ToolCall{
+				ID:        block.ID,
+				Name:      block.Name,
+				Arguments: string(block.Input),
+			})
+		}
 	}
+	return result, nil
+}
+
+// ChatStream implements StreamingProvider using the Messages API event stream
+func (s *AnthropicService) ChatStream(ctx context.Context, chatReq ChatRequest, onDelta func(string)) (ChatResult, error) {
+	chatReq.Tools = nil
+	reqBody := s.newRequest(chatReq)
+	reqBody.Stream = true
 
-	return anthropicResp.Content[0].Text, nil
+	req, err := s.newHTTPRequest(ctx, reqBody)
+	if err != nil {
+		return ChatResult{}, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := s.streamClient.Do(req)
+	if err != nil {
+		return ChatResult{}, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return ChatResult{}, &StatusError{Backend: "anthropic", StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var result ChatResult
+	var usage AnthropicUsage
+	var full strings.Builder
+	err = readSSE(resp.Body, func(_, data string) error {
+		var event AnthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("failed to parse stream event: %w", err)
+		}
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				result.Model = event.Message.Model
+				if event.Message.Usage != nil {
+					usage = *event.Message.Usage
+				}
+			}
+		case "message_delta":
+			if event.Delta.StopReason != "" {
+				result.FinishReason = finishReason(event.Delta.StopReason)
+			}
+			if event.Usage != nil {
+				usage.OutputTokens = event.Usage.OutputTokens
+				result.Usage = usage.toModel()
+			}
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+				full.WriteString(event.Delta.Text)
+				onDelta(event.Delta.Text)
+			}
+		case "error":
+			if event.Error != nil {
+				return fmt.Errorf("anthropic API error: %s", event.Error.Message)
+			}
+			return fmt.Errorf("anthropic API error")
+		case "message_stop":
+			return io.EOF
+		}
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return ChatResult{}, fmt.Errorf("failed to read Anthropic stream: %w", err)
+	}
+
+	if full.Len() == 0 {
+		return ChatResult{}, fmt.Errorf("empty response from Anthropic")
+	}
+	result.Content = full.String()
+	return result, nil
+}
+
+// toModel converts Anthropic's input and output token counts
+func (u AnthropicUsage) toModel() *models.Usage {
+	return &models.Usage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.InputTokens + u.OutputTokens,
+	}
+}
+
+// finishReason maps an Anthropic stop_reason to the OpenAI-style finish reason
+// used for vLLM, so both providers report the same values
+func finishReason(stopReason string) string {
+	switch stopReason {
+	case "end_turn", "stop_sequence":
+		return "stop"
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	}
+	return stopReason
 }
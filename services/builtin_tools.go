@@ -0,0 +1,229 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+func init() {
+	RegisterTool(currentTimeTool{})
+	RegisterTool(calculatorTool{})
+}
+
+// currentTimeTool tells the model the current date and time
+type currentTimeTool struct{}
+
+func (currentTimeTool) Name() string { return "current_time" }
+
+func (currentTimeTool) Description() string {
+	return "Get the current date and time, optionally in a given IANA time zone such as Europe/Berlin."
+}
+
+func (currentTimeTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"timezone": {"type": "string", "description": "IANA time zone name; defaults to UTC"}
+		}
+	}`)
+}
+
+func (currentTimeTool) Execute(_ context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Timezone string `json:"timezone"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	loc := time.UTC
+	if args.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(args.Timezone); err != nil {
+			return "", fmt.Errorf("unknown time zone %q", args.Timezone)
+		}
+	}
+	return time.Now().In(loc).Format("Monday, 2 January 2006 15:04:05 MST"), nil
+}
+
+// calculatorTool evaluates arithmetic so the model does not have to
+type calculatorTool struct{}
+
+func (calculatorTool) Name() string { return "calculator" }
+
+func (calculatorTool) Description() string {
+	return "Evaluate an arithmetic expression with + - * / % ^ and parentheses, e.g. (3.5 + 2) * 4^2."
+}
+
+func (calculatorTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"expression": {"type": "string", "description": "The expression to evaluate"}
+		},
+		"required": ["expression"]
+	}`)
+}
+
+func (calculatorTool) Execute(_ context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	p := &exprParser{input: args.Expression}
+	v, err := p.parse()
+	if err != nil {
+		return "", err
+	}
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return "", fmt.Errorf("result is not a finite number")
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64), nil
+}
+
+// exprParser is a recursive descent parser for arithmetic expressions:
+//
+//	expr   = term { ("+" | "-") term }
+//	term   = unary { ("*" | "/" | "%") unary }
+//	unary  = ("+" | "-") unary | power
+//	power  = atom [ "^" unary ]
+//	atom   = number | "(" expr ")"
+type exprParser struct {
+	input string
+	pos   int
+}
+
+func (p *exprParser) parse() (float64, error) {
+	v, err := p.expr()
+	if err != nil {
+		return 0, err
+	}
+	if p.skipSpace(); p.pos < len(p.input) {
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos+1)
+	}
+	return v, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// accept consumes op if it is the next non-space character
+func (p *exprParser) accept(op byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.input) && p.input[p.pos] == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expr() (float64, error) {
+	v, err := p.term()
+	for err == nil {
+		var rhs float64
+		switch {
+		case p.accept('+'):
+			rhs, err = p.term()
+			v += rhs
+		case p.accept('-'):
+			rhs, err = p.term()
+			v -= rhs
+		default:
+			return v, nil
+		}
+	}
+	return 0, err
+}
+
+func (p *exprParser) term() (float64, error) {
+	v, err := p.unary()
+	for err == nil {
+		var rhs float64
+		switch {
+		case p.accept('*'):
+			rhs, err = p.unary()
+			v *= rhs
+		case p.accept('/'):
+			if rhs, err = p.unary(); err == nil && rhs == 0 {
+				err = fmt.Errorf("division by zero")
+			}
+			v /= rhs
+		case p.accept('%'):
+			if rhs, err = p.unary(); err == nil && rhs == 0 {
+				err = fmt.Errorf("division by zero")
+			}
+			v = math.Mod(v, rhs)
+		default:
+			return v, nil
+		}
+	}
+	return 0, err
+}
+
+func (p *exprParser) unary() (float64, error) {
+	switch {
+	case p.accept('-'):
+		v, err := p.unary()
+		return -v, err
+	case p.accept('+'):
+		return p.unary()
+	}
+	return p.power()
+}
+
+func (p *exprParser) power() (float64, error) {
+	base, err := p.atom()
+	if err != nil {
+		return 0, err
+	}
+	if p.accept('^') {
+		exp, err := p.unary()
+		if err != nil {
+			return 0, err
+		}
+		return math.Pow(base, exp), nil
+	}
+	return base, nil
+}
+
+func (p *exprParser) atom() (float64, error) {
+	if p.accept('(') {
+		v, err := p.expr()
+		if err != nil {
+			return 0, err
+		}
+		if !p.accept(')') {
+			return 0, fmt.Errorf("missing closing parenthesis")
+		}
+		return v, nil
+	}
+
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) && (p.input[p.pos] >= '0' && p.input[p.pos] <= '9' || p.input[p.pos] == '.' ||
+		p.input[p.pos] == 'e' || p.input[p.pos] == 'E' ||
+		(p.pos > start && (p.input[p.pos] == '-' || p.input[p.pos] == '+') && strings.ContainsRune("eE", rune(p.input[p.pos-1])))) {
+		p.pos++
+	}
+	if start == p.pos {
+		if p.pos >= len(p.input) {
+			return 0, fmt.Errorf("unexpected end of expression")
+		}
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos+1)
+	}
+	v, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", p.input[start:p.pos])
+	}
+	return v, nil
+}
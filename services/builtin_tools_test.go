@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestExprParser(t *testing.T) {
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		// Literals
+		{"42", 42},
+		{"3.5", 3.5},
+		{".5", 0.5},
+		{"  7  ", 7},
+		// Exponent notation
+		{"1e3", 1000},
+		{"1e-3", 0.001},
+		{"2.5E+2", 250},
+		{"1e-3 * 1000", 1},
+		{"2e1-1", 19},
+		// Precedence
+		{"2 + 3 * 4", 14},
+		{"(2 + 3) * 4", 20},
+		{"10 - 4 - 3", 3},
+		{"100 / 10 / 5", 2},
+		{"7 % 4 * 2", 6},
+		{"2 * 3 ^ 2", 18},
+		{"1 + 2 * 3 ^ 2 - 4 / 2", 17},
+		// Unary minus and plus
+		{"-3", -3},
+		{"--3", 3},
+		{"+3", 3},
+		{"2 * -3", -6},
+		{"-(2 + 3)", -5},
+		{"-2 ^ 2", -4},
+		{"2 ^ -1", 0.5},
+		{"(-2) ^ 2", 4},
+		// ^ is right-associative
+		{"2 ^ 3 ^ 2", 512},
+		{"(2 ^ 3) ^ 2", 64},
+		{"2 ^ -1 ^ 2", 0.5},
+		// Parentheses
+		{"((1))", 1},
+		{"(1 + (2 * (3 + 4)))", 15},
+		{"-7 % 3", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			p := &exprParser{input: tt.expr}
+			got, err := p.parse()
+			if err != nil {
+				t.Fatalf("parse(%q): %v", tt.expr, err)
+			}
+			if math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("parse(%q) = %v, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExprParserErrors(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{"1 / 0", "division by zero"},
+		{"1 / (2 - 2)", "division by zero"},
+		{"5 % 0", "division by zero"},
+		{"(1 + 2", "missing closing parenthesis"},
+		{"((1 + 2)", "missing closing parenthesis"},
+		{"1 + 2)", `unexpected ')'`},
+		{")", `unexpected ')'`},
+		{"()", `unexpected ')'`},
+		{"", "unexpected end of expression"},
+		{"1 +", "unexpected end of expression"},
+		{"2 ^", "unexpected end of expression"},
+		{"1 2", `unexpected '2'`},
+		{"2 * x", `unexpected 'x'`},
+		{"1..2", "invalid number"},
+		{"1e", "invalid number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			p := &exprParser{input: tt.expr}
+			v, err := p.parse()
+			if err == nil {
+				t.Fatalf("parse(%q) = %v, want error", tt.expr, v)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parse(%q) error = %q, want it to contain %q", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCalculatorTool(t *testing.T) {
+	tests := []struct {
+		args    string
+		want    string
+		wantErr bool
+	}{
+		{`{"expression": "(3.5 + 2) * 4^2"}`, "88", false},
+		{`{"expression": "1/3"}`, "0.3333333333333333", false},
+		{`{"expression": "10^400"}`, "", true},
+		{`{"expression": 5}`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			got, err := calculatorTool{}.Execute(context.Background(), json.RawMessage(tt.args))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Execute(%s) = %q, want error", tt.args, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Execute(%s) = %q, %v, want %q", tt.args, got, err, tt.want)
+			}
+		})
+	}
+}
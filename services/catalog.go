@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"chat-app/models"
+)
+
+// ModelLister is implemented by providers that can list the models they serve
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]models.ModelInfo, error)
+}
+
+// catalogRefreshTimeout bounds one listing of the provider's models
+const catalogRefreshTimeout = 30 * time.Second
+
+// ModelCatalog caches the models the provider can answer with
+type ModelCatalog struct {
+	provider Provider
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	models  []models.ModelInfo
+	fetched time.Time
+	// err is why the last refresh failed, if it did
+	err error
+	// refreshing is closed once the refresh in progress, if any, is over
+	refreshing chan struct{}
+}
+
+// NewModelCatalog creates a catalog that lists the provider's models at most once per ttl
+func NewModelCatalog(provider Provider, ttl time.Duration) *ModelCatalog {
+	return &ModelCatalog{provider: provider, ttl: ttl}
+}
+
+// Models returns the provider's models. Once the cache has expired the list is
+// fetched again in the background, without holding the lock; callers get the
+// previous list meanwhile, and only wait when there is none yet. If listing fails,
+// the previous list is kept as long as there is one. A provider that cannot list
+// its models has none.
+func (c *ModelCatalog) Models(ctx context.Context) ([]models.ModelInfo, error) {
+	lister, ok := c.provider.(ModelLister)
+	if !ok {
+		return []models.ModelInfo{}, nil
+	}
+
+	c.mu.Lock()
+	if c.models != nil && time.Since(c.fetched) < c.ttl {
+		defer c.mu.Unlock()
+		return c.models, nil
+	}
+	done := c.refreshing
+	if done == nil {
+		done = make(chan struct{})
+		c.refreshing = done
+		go c.refresh(lister, done)
+	}
+	cached := c.models
+	c.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.models == nil {
+		return nil, c.err
+	}
+	return c.models, nil
+}
+
+// refresh lists the provider's models and closes done. It is detached from the
+// request that started it, so one caller giving up does not fail the others.
+func (c *ModelCatalog) refresh(lister ModelLister, done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), catalogRefreshTimeout)
+	defer cancel()
+	list, err := lister.ListModels(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer close(done)
+	c.refreshing = nil
+	if err != nil {
+		if c.models != nil {
+			log.Printf("Failed to list models, keeping the cached list: %v", err)
+		}
+		c.err = err
+		return
+	}
+	if list == nil {
+		list = []models.ModelInfo{}
+	}
+	c.models, c.fetched, c.err = list, time.Now(), nil
+}
+
+// ContextLength returns the context window of a model, or of the default model if
+// model is empty. It is 0 if the model is unknown or its length was not reported.
+func (c *ModelCatalog) ContextLength(ctx context.Context, model string) int {
+	list, err := c.Models(ctx)
+	if err != nil {
+		return 0
+	}
+	for _, m := range list {
+		if m.ID == model || (model == "" && m.Default) {
+			return m.MaxContextLength
+		}
+	}
+	return 0
+}
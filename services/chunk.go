@@ -0,0 +1,128 @@
+package services
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	// DefaultChunkSize is the target chunk length in characters (roughly 350 tokens)
+	DefaultChunkSize = 1400
+	// DefaultChunkOverlap is how many trailing characters of a chunk are repeated at the start of the next
+	DefaultChunkOverlap = 200
+)
+
+// ChunkText splits a document into overlapping chunks of at most size characters.
+// Paragraphs are kept together where possible; paragraphs longer than a chunk are
+// split at word boundaries. Each chunk after the first starts with the tail of the
+// previous one so a passage cut at a boundary is still retrievable as a whole,
+// unless the paragraph that follows only fits in a chunk without it.
+func ChunkText(text string, size, overlap int) []string {
+	if overlap >= size {
+		overlap = size / 4
+	}
+	// Parts of a split paragraph leave room for the overlap and the separator after it
+	partSize := size
+	if overlap > 0 {
+		partSize = max(size-overlap-2, 1)
+	}
+
+	// Break the text into pieces that each fit in a chunk
+	var pieces []string
+	for _, para := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		para = strings.TrimSpace(para)
+		if para == "" {
+			continue
+		}
+		if runeLen(para) <= size {
+			pieces = append(pieces, para)
+			continue
+		}
+		pieces = append(pieces, splitWords(para, partSize)...)
+	}
+
+	var chunks []string
+	var current strings.Builder
+	added := false // whether current holds more than the carried-over overlap
+	flush := func() {
+		chunk := current.String()
+		chunks = append(chunks, chunk)
+		current.Reset()
+		added = false
+		if overlap > 0 {
+			current.WriteString(tail(chunk, overlap))
+		}
+	}
+	for _, piece := range pieces {
+		if current.Len() > 0 && runeLen(current.String())+2+runeLen(piece) > size {
+			if added {
+				flush()
+			}
+			if runeLen(current.String())+2+runeLen(piece) > size {
+				// The overlap does not fit alongside this piece
+				current.Reset()
+			}
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(piece)
+		added = true
+	}
+	if added {
+		flush()
+	}
+	return chunks
+}
+
+// splitWords splits text into parts of at most size characters at whitespace
+func splitWords(text string, size int) []string {
+	if runeLen(text) <= size {
+		return []string{text}
+	}
+	var parts []string
+	var current strings.Builder
+	for _, word := range strings.Fields(text) {
+		// A single word longer than size is hard-split
+		for runeLen(word) > size {
+			if current.Len() > 0 {
+				parts = append(parts, current.String())
+				current.Reset()
+			}
+			r := []rune(word)
+			parts = append(parts, string(r[:size]))
+			word = string(r[size:])
+		}
+		if current.Len() > 0 && runeLen(current.String())+1+runeLen(word) > size {
+			parts = append(parts, current.String())
+			current.Reset()
+		}
+		if current.Len() > 0 {
+			current.WriteByte(' ')
+		}
+		current.WriteString(word)
+	}
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+// tail returns roughly the last n characters of s, starting at a word boundary
+func tail(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	t := r[len(r)-n:]
+	for i, c := range t {
+		if unicode.IsSpace(c) {
+			return strings.TrimSpace(string(t[i:]))
+		}
+	}
+	return string(t)
+}
+
+func runeLen(s string) int {
+	return len([]rune(s))
+}
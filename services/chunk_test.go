@@ -0,0 +1,107 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChunkText(t *testing.T) {
+	para := func(word string, n int) string { return strings.TrimSpace(strings.Repeat(word+" ", n)) }
+	tests := []struct {
+		name       string
+		text       string
+		size       int
+		overlap    int
+		wantChunks int
+	}{
+		{"empty", "", 100, 20, 0},
+		{"whitespace only", " \n\n \n", 100, 20, 0},
+		{"shorter than one chunk", "A short note.", 100, 20, 1},
+		{"just under one chunk", para("word", 18), 100, 20, 1},
+		{"exactly one chunk", strings.Repeat("a", 100), 100, 20, 1},
+		{"paragraphs packed together", "one\n\ntwo\n\nthree", 100, 20, 1},
+		{"CRLF paragraphs", "one\r\n\r\ntwo", 100, 20, 1},
+		{"paragraphs split across chunks", para("alpha", 10) + "\n\n" + para("bravo", 10) + "\n\n" + para("charlie", 10), 80, 20, 3},
+		{"long paragraph split at words", para("word", 100), 100, 20, 7},
+		{"long word hard-split", strings.Repeat("x", 250), 100, 20, 4},
+		{"no overlap", para("word", 100), 100, 0, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := ChunkText(tt.text, tt.size, tt.overlap)
+			if len(chunks) != tt.wantChunks {
+				t.Fatalf("got %d chunks, want %d: %q", len(chunks), tt.wantChunks, chunks)
+			}
+			for i, chunk := range chunks {
+				if n := runeLen(chunk); n > tt.size {
+					t.Errorf("chunk %d has %d characters, more than %d", i, n, tt.size)
+				}
+				if strings.TrimSpace(chunk) == "" {
+					t.Errorf("chunk %d is blank", i)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkTextOverlap(t *testing.T) {
+	text := strings.TrimSpace(strings.Repeat("lorem ipsum dolor sit amet ", 40))
+	chunks := ChunkText(text, 200, 50)
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want several", len(chunks))
+	}
+	for i := 1; i < len(chunks); i++ {
+		carried := tail(chunks[i-1], 50)
+		if carried == "" || !strings.HasPrefix(chunks[i], carried) {
+			t.Errorf("chunk %d does not start with the tail %q of chunk %d", i, carried, i-1)
+		}
+		if n := runeLen(carried); n > 50 {
+			t.Errorf("overlap into chunk %d is %d characters, more than 50", i, n)
+		}
+	}
+}
+
+func TestChunkTextCoversAllWords(t *testing.T) {
+	var words []string
+	for i := 0; i < 300; i++ {
+		words = append(words, "w"+strings.Repeat("x", i%7))
+	}
+	chunks := ChunkText(strings.Join(words, " "), 120, 30)
+	joined := " " + strings.Join(chunks, " ") + " "
+	for _, w := range words {
+		if !strings.Contains(joined, " "+w+" ") {
+			t.Fatalf("word %q missing from chunks", w)
+		}
+	}
+}
+
+func TestChunkTextOverlapNotSmallerThanSize(t *testing.T) {
+	// An overlap at least as large as the chunk size falls back to a quarter of it
+	chunks := ChunkText(strings.TrimSpace(strings.Repeat("word ", 100)), 100, 100)
+	for i, chunk := range chunks {
+		if n := runeLen(chunk); n > 100 {
+			t.Errorf("chunk %d has %d characters, more than 100", i, n)
+		}
+	}
+	if len(chunks) < 2 {
+		t.Errorf("got %d chunks, want several", len(chunks))
+	}
+}
+
+func TestTail(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"the quick brown fox", 9, "fox"},
+		{"the quick brown fox", 10, "brown fox"},
+		{"abcdefghij", 4, "ghij"},
+	}
+	for _, tt := range tests {
+		if got := tail(tt.s, tt.n); got != tt.want {
+			t.Errorf("tail(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
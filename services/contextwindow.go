@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"chat-app/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultContextWindowTokens = 32768
+	// Chat templates add a few tokens around every message and before the reply
+	perMessageTokenOverhead = 4
+	replyPrimingTokens      = 3
+	// maxCachedTokenCounts bounds the tokenizer cache; it is cleared when full
+	maxCachedTokenCounts = 100000
+)
+
+// TokenCounter is implemented by providers that can count tokens with the model's own tokenizer
+type TokenCounter interface {
+	CountTokens(ctx context.Context, model, text string) (int, error)
+}
+
+// EstimateTokens approximates the token count of text (roughly four characters per token)
+func EstimateTokens(text string) int {
+	n := len([]rune(text))
+	return (n + 3) / 4
+}
+
+// ContextConfig controls how much conversation history is sent to the model
+type ContextConfig struct {
+	// WindowTokens is the model's context length; the completion's max_tokens is reserved
+	// from it. Zero means the length the model catalog reports, or 32768 if unknown.
+	WindowTokens int
+	// UseTokenizer counts tokens with the provider's tokenizer instead of estimating
+	UseTokenizer bool
+	// Summarize folds history that no longer fits into a rolling summary instead of dropping it
+	Summarize bool
+}
+
+// LoadContextConfig reads CONTEXT_WINDOW_TOKENS (default the model's reported length),
+// CONTEXT_TOKENIZER ("estimate" or "provider", default "estimate") and
+// CONTEXT_SUMMARIZE ("false" disables rolling summaries)
+func LoadContextConfig() ContextConfig {
+	cfg := ContextConfig{Summarize: true}
+	if v := os.Getenv("CONTEXT_WINDOW_TOKENS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.WindowTokens = n
+		} else {
+			log.Printf("Ignoring invalid CONTEXT_WINDOW_TOKENS %q", v)
+		}
+	}
+	cfg.UseTokenizer = os.Getenv("CONTEXT_TOKENIZER") == "provider"
+	if v := os.Getenv("CONTEXT_SUMMARIZE"); v != "" {
+		if enabled, err := strconv.ParseBool(v); err == nil {
+			cfg.Summarize = enabled
+		} else {
+			log.Printf("Ignoring invalid CONTEXT_SUMMARIZE %q", v)
+		}
+	}
+	return cfg
+}
+
+// SummarizeEnabled reports whether overflowing history should be summarized
+func (b *ContextBuilder) SummarizeEnabled() bool {
+	return b.cfg.Summarize
+}
+
+// ContextWindow is the history selected to fit the prompt budget
+type ContextWindow struct {
+	Messages     []models.Message
+	PromptTokens int
+	Budget       int
+}
+
+// ContextBuilder selects the most recent messages that fit the prompt budget
+type ContextBuilder struct {
+	cfg     ContextConfig
+	counter TokenCounter
+	// catalog reports context lengths; nil uses the default length
+	catalog *ModelCatalog
+
+	mu sync.Mutex
+	// counts caches the tokenizer's count of stored messages. A saved message never
+	// changes, so each is only sent to the tokenizer once per model.
+	counts map[tokenCountKey]int
+}
+
+// tokenCountKey identifies a message counted with a model's tokenizer
+type tokenCountKey struct {
+	model     string
+	messageID uuid.UUID
+}
+
+// NewContextBuilder creates a context builder. If cfg.UseTokenizer is set and the
+// provider implements TokenCounter, its tokenizer is used for counting. Unless
+// cfg.WindowTokens is set, context lengths are looked up in catalog.
+func NewContextBuilder(cfg ContextConfig, provider Provider, catalog *ModelCatalog) *ContextBuilder {
+	b := &ContextBuilder{cfg: cfg, catalog: catalog, counts: make(map[tokenCountKey]int)}
+	if cfg.UseTokenizer {
+		if counter, ok := provider.(TokenCounter); ok {
+			b.counter = counter
+		} else {
+			log.Printf("Provider %s cannot count tokens; estimating instead", provider.Name())
+		}
+	}
+	return b
+}
+
+// Build returns the newest messages of req.Messages that fit in the prompt budget.
+// The system prompt, the new user message, this turn's tool messages and the tool
+// specs are always kept, even if they alone exceed the budget.
+func (b *ContextBuilder) Build(ctx context.Context, req ChatRequest) ContextWindow {
+	budget := b.budget(ctx, req)
+	fixed, costs := b.costs(ctx, req)
+	start, used := fit(fixed, costs, budget)
+	return ContextWindow{
+		Messages:     req.Messages[start:],
+		PromptTokens: used,
+		Budget:       budget,
+	}
+}
+
+// SummaryCut returns how many of the oldest req.Messages should be folded into a
+// summary. It is zero while the whole history fits; once it overflows, enough is
+// cut that the remainder fills only half the budget, so summaries are not
+// regenerated on every turn.
+func (b *ContextBuilder) SummaryCut(ctx context.Context, req ChatRequest) int {
+	budget := b.budget(ctx, req)
+	fixed, costs := b.costs(ctx, req)
+	if start, _ := fit(fixed, costs, budget); start == 0 {
+		return 0
+	}
+	start, _ := fit(fixed, costs, budget/2)
+	if start == 0 {
+		start = 1
+	}
+	return start
+}
+
+// budget returns the prompt token budget: the context window minus the completion reserve
+func (b *ContextBuilder) budget(ctx context.Context, req ChatRequest) int {
+	completion := defaultMaxTokens
+	if req.Params.MaxTokens != nil {
+		completion = *req.Params.MaxTokens
+	}
+	return b.windowTokens(ctx, req.Params) - completion
+}
+
+// windowTokens returns the context length of the model params select
+func (b *ContextBuilder) windowTokens(ctx context.Context, params models.ModelParams) int {
+	if b.cfg.WindowTokens > 0 {
+		return b.cfg.WindowTokens
+	}
+	if b.catalog != nil {
+		var model string
+		if params.Model != nil {
+			model = *params.Model
+		}
+		if n := b.catalog.ContextLength(ctx, model); n > 0 {
+			return n
+		}
+	}
+	return defaultContextWindowTokens
+}
+
+// costs counts the tokens of everything that is always sent: the system prompt
+// (which carries any summary and document excerpts), the user message, this turn's
+// tool calls and results and the tool specs. It also returns the cost of each
+// history message.
+func (b *ContextBuilder) costs(ctx context.Context, req ChatRequest) (int, []int) {
+	model := ""
+	if req.Params.Model != nil {
+		model = *req.Params.Model
+	}
+
+	fixed := replyPrimingTokens
+	if req.System != "" {
+		fixed += b.count(ctx, model, req.System) + perMessageTokenOverhead
+	}
+	fixed += b.count(ctx, model, req.UserMessage) + perMessageTokenOverhead
+	for _, msg := range req.Turn {
+		fixed += b.countMessage(ctx, model, msg) + perMessageTokenOverhead
+	}
+	if len(req.Tools) > 0 {
+		fixed += b.count(ctx, model, toolSpecsText(req.Tools)) + perMessageTokenOverhead*len(req.Tools)
+	}
+
+	history := make([]int, len(req.Messages))
+	for i, msg := range req.Messages {
+		history[i] = b.countMessage(ctx, model, msg) + perMessageTokenOverhead
+	}
+	return fixed, history
+}
+
+// fit walks history from newest to oldest until budget is spent. It returns the
+// index of the oldest message that fits and the prompt tokens used.
+func fit(fixed int, history []int, budget int) (int, int) {
+	used := fixed
+	start := len(history)
+	for i := len(history) - 1; i >= 0; i-- {
+		if used+history[i] > budget {
+			break
+		}
+		used += history[i]
+		start = i
+	}
+	return start, used
+}
+
+// countMessage returns the token count of a message, from the cache if it has been
+// counted with the tokenizer before
+func (b *ContextBuilder) countMessage(ctx context.Context, model string, msg models.Message) int {
+	text := MessageText(msg)
+	if b.counter == nil || msg.ID == uuid.Nil {
+		return b.count(ctx, model, text)
+	}
+
+	key := tokenCountKey{model: model, messageID: msg.ID}
+	b.mu.Lock()
+	n, ok := b.counts[key]
+	b.mu.Unlock()
+	if ok {
+		return n
+	}
+
+	n, err := b.counter.CountTokens(ctx, model, text)
+	if err != nil {
+		log.Printf("Tokenizer failed, estimating instead: %v", err)
+		return EstimateTokens(text)
+	}
+	b.mu.Lock()
+	if len(b.counts) >= maxCachedTokenCounts {
+		clear(b.counts)
+	}
+	b.counts[key] = n
+	b.mu.Unlock()
+	return n
+}
+
+// count returns the token count of text, falling back to an estimate if the tokenizer fails
+func (b *ContextBuilder) count(ctx context.Context, model, text string) int {
+	if b.counter != nil {
+		n, err := b.counter.CountTokens(ctx, model, text)
+		if err == nil {
+			return n
+		}
+		log.Printf("Tokenizer failed, estimating instead: %v", err)
+	}
+	return EstimateTokens(text)
+}
+
+// toolSpecsText approximates how tool specs appear in the prompt, for counting
+func toolSpecsText(tools []ToolSpec) string {
+	var b strings.Builder
+	for _, t := range tools {
+		b.WriteString(t.Name)
+		b.WriteByte('\n')
+		b.WriteString(t.Description)
+		b.WriteByte('\n')
+		b.Write(t.Parameters)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"chat-app/models"
+
+	"github.com/google/uuid"
+)
+
+// countingProvider counts one token per byte and records every tokenizer call
+type countingProvider struct {
+	calls int
+}
+
+func (p *countingProvider) Name() string { return "counting" }
+
+func (p *countingProvider) Chat(context.Context, ChatRequest) (ChatResult, error) {
+	return ChatResult{}, nil
+}
+
+func (p *countingProvider) CountTokens(_ context.Context, _, text string) (int, error) {
+	p.calls++
+	return len(text), nil
+}
+
+func history(contents ...string) []models.Message {
+	msgs := make([]models.Message, len(contents))
+	for i, c := range contents {
+		msgs[i] = models.Message{ID: uuid.New(), Role: models.RoleUser, Content: c}
+	}
+	return msgs
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		name      string
+		fixed     int
+		history   []int
+		budget    int
+		wantStart int
+		wantUsed  int
+	}{
+		{"everything fits", 10, []int{5, 5, 5}, 100, 0, 25},
+		{"oldest dropped", 10, []int{50, 20, 20}, 60, 1, 50},
+		{"newest only", 10, []int{5, 5, 45}, 59, 2, 55},
+		{"a gap does not skip ahead", 10, []int{1, 100, 5}, 60, 2, 15},
+		{"fixed over budget", 80, []int{5}, 60, 1, 80},
+		{"no history", 10, nil, 60, 0, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, used := fit(tt.fixed, tt.history, tt.budget)
+			if start != tt.wantStart || used != tt.wantUsed {
+				t.Errorf("fit = (%d, %d), want (%d, %d)", start, used, tt.wantStart, tt.wantUsed)
+			}
+		})
+	}
+}
+
+func TestBuildCachesMessageCounts(t *testing.T) {
+	counter := &countingProvider{}
+	b := NewContextBuilder(ContextConfig{WindowTokens: 10000, UseTokenizer: true}, counter, nil)
+	req := ChatRequest{System: "system", Messages: history("a", "b", "c"), UserMessage: "hi"}
+
+	b.Build(context.Background(), req)
+	// System prompt, user message and three history messages
+	if counter.calls != 5 {
+		t.Fatalf("first Build made %d tokenizer calls, want 5", counter.calls)
+	}
+	counter.calls = 0
+	b.SummaryCut(context.Background(), req)
+	b.Build(context.Background(), req)
+	// Only the system prompt and user message are counted again
+	if counter.calls != 4 {
+		t.Errorf("later calls made %d tokenizer calls, want 4", counter.calls)
+	}
+}
+
+func TestBuildCountsToolsAndTurn(t *testing.T) {
+	b := NewContextBuilder(ContextConfig{WindowTokens: 10000}, &countingProvider{}, nil)
+	req := ChatRequest{Messages: history("a"), UserMessage: "hi"}
+	base := b.Build(context.Background(), req).PromptTokens
+
+	req.Tools = []ToolSpec{{Name: "calculator", Description: "Evaluates arithmetic", Parameters: json.RawMessage(`{"type":"object"}`)}}
+	req.Turn = history("calculator(1+1)")
+	if got := b.Build(context.Background(), req).PromptTokens; got <= base {
+		t.Errorf("PromptTokens with tools and turn = %d, want more than %d", got, base)
+	}
+}
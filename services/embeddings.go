@@ -0,0 +1,182 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// embeddingBatchSize is how many inputs are sent per /v1/embeddings request
+	embeddingBatchSize = 32
+	defaultRAGTopK     = 4
+	defaultRAGMinScore = 0.3
+)
+
+// EmbeddingConfig selects the embedding model and controls retrieval
+type EmbeddingConfig struct {
+	// BaseURL of an OpenAI-compatible server (usually a vLLM instance serving an embedding model)
+	BaseURL string
+	// Model is the embedding model; empty disables document ingestion and retrieval
+	Model string
+	// TopK is how many document chunks are injected into each prompt
+	TopK int
+	// MinScore is the minimum cosine similarity for a chunk to be injected
+	MinScore float64
+}
+
+// LoadEmbeddingConfig reads EMBEDDING_MODEL, EMBEDDING_BASE_URL (default the first
+// VLLM_BASE_URL), RAG_TOP_K (default 4) and RAG_MIN_SCORE (default 0.3)
+func LoadEmbeddingConfig() EmbeddingConfig {
+	cfg := EmbeddingConfig{
+		BaseURL:  os.Getenv("EMBEDDING_BASE_URL"),
+		Model:    os.Getenv("EMBEDDING_MODEL"),
+		TopK:     defaultRAGTopK,
+		MinScore: defaultRAGMinScore,
+	}
+	if urls := ParseBaseURLs(os.Getenv("VLLM_BASE_URL")); cfg.BaseURL == "" && len(urls) > 0 {
+		cfg.BaseURL = urls[0]
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "http://localhost:5000"
+	}
+	if v := os.Getenv("RAG_TOP_K"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.TopK = n
+		} else {
+			log.Printf("Ignoring invalid RAG_TOP_K %q", v)
+		}
+	}
+	if v := os.Getenv("RAG_MIN_SCORE"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			cfg.MinScore = f
+		} else {
+			log.Printf("Ignoring invalid RAG_MIN_SCORE %q", v)
+		}
+	}
+	return cfg
+}
+
+// EmbeddingService turns text into vectors using the /v1/embeddings endpoint
+type EmbeddingService struct {
+	cfg    EmbeddingConfig
+	client *http.Client
+}
+
+// NewEmbeddingService creates an embedding client, or returns nil if no model is configured
+func NewEmbeddingService(cfg EmbeddingConfig) *EmbeddingService {
+	if cfg.Model == "" {
+		return nil
+	}
+	return &EmbeddingService{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 120 * time.Second},
+	}
+}
+
+// TopK returns how many chunks to retrieve per prompt
+func (s *EmbeddingService) TopK() int {
+	return s.cfg.TopK
+}
+
+// MinScore returns the minimum similarity for a retrieved chunk
+func (s *EmbeddingService) MinScore() float64 {
+	return s.cfg.MinScore
+}
+
+// EmbeddingRequest is the request body of /v1/embeddings
+type EmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// EmbeddingResponse is the response of /v1/embeddings
+type EmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Embed returns one unit-length vector per input, in order. Inputs are sent in batches.
+func (s *EmbeddingService) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(inputs))
+	for start := 0; start < len(inputs); start += embeddingBatchSize {
+		batch, err := s.embedBatch(ctx, inputs[start:min(start+embeddingBatchSize, len(inputs))])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+func (s *EmbeddingService) embedBatch(ctx context.Context, inputs []string) ([][]float32, error) {
+	jsonData, err := json.Marshal(EmbeddingRequest{Model: s.cfg.Model, Input: inputs})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/v1/embeddings", s.cfg.BaseURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to embedding server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding API error (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	var embResp EmbeddingResponse
+	if err := json.Unmarshal(body, &embResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if len(embResp.Data) != len(inputs) {
+		return nil, fmt.Errorf("embedding server returned %d vectors for %d inputs", len(embResp.Data), len(inputs))
+	}
+
+	vectors := make([][]float32, len(inputs))
+	for _, d := range embResp.Data {
+		if d.Index < 0 || d.Index >= len(inputs) {
+			return nil, fmt.Errorf("embedding server returned out-of-range index %d", d.Index)
+		}
+		vectors[d.Index] = normalize(d.Embedding)
+	}
+	return vectors, nil
+}
+
+// normalize scales v to unit length so cosine similarity is a plain dot product
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	norm := float32(math.Sqrt(sum))
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = x / norm
+	}
+	return out
+}
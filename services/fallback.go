@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"chat-app/models"
+)
+
+// FallbackProvider tries an ordered chain of providers, falling over to the next
+// one when a request fails. A request for a model one of the providers lists goes
+// to the provider serving it first. Fallbacks answer with their own default model, since
+// a model chosen for one provider usually means nothing to the others.
+// Capabilities a provider lacks are covered by its plain Chat.
+type FallbackProvider struct {
+	providers []Provider
+
+	mu sync.RWMutex
+	// owners maps each listed model to the index of the provider serving it
+	owners map[string]int
+	// listed is when the providers' models were last listed, successfully or not
+	listed time.Time
+}
+
+// ownersRefreshInterval is how often a request for a model no provider is known to
+// serve may list the providers' models again
+const ownersRefreshInterval = time.Minute
+
+// NewFallbackProvider chains providers in the order they should be tried
+func NewFallbackProvider(providers ...Provider) *FallbackProvider {
+	return &FallbackProvider{providers: providers}
+}
+
+// Name returns the name of the first provider in the chain
+func (f *FallbackProvider) Name() string {
+	return f.providers[0].Name()
+}
+
+// order returns the providers in the order a request for model should try them
+func (f *FallbackProvider) order(ctx context.Context, model *string) []Provider {
+	if model == nil {
+		return f.providers
+	}
+	owner, ok := f.owner(ctx, *model)
+	if !ok || owner == 0 {
+		return f.providers
+	}
+	order := append([]Provider{f.providers[owner]}, f.providers[:owner]...)
+	return append(order, f.providers[owner+1:]...)
+}
+
+// owner returns the index of the provider serving model. The providers' models
+// are listed on first use, and again when an unknown model is asked for, at most
+// once per ownersRefreshInterval.
+func (f *FallbackProvider) owner(ctx context.Context, model string) (int, bool) {
+	f.mu.RLock()
+	owner, ok := f.owners[model]
+	stale := time.Since(f.listed) >= ownersRefreshInterval
+	f.mu.RUnlock()
+	if ok || !stale {
+		return owner, ok
+	}
+
+	if _, err := f.ListModels(ctx); err != nil {
+		log.Printf("Failed to list models for routing: %v", err)
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	owner, ok = f.owners[model]
+	return owner, ok
+}
+
+// try calls fn with each provider in turn until one succeeds. If all fail, the
+// errors are joined. If committed is set and reports true after a failure, that
+// failure is returned without trying the remaining providers.
+func (f *FallbackProvider) try(ctx context.Context, req ChatRequest, fn func(Provider, ChatRequest) (ChatResult, error), committed func() bool) (ChatResult, error) {
+	providers := f.order(ctx, req.Params.Model)
+	var errs []error
+	for i, p := range providers {
+		if i > 0 {
+			req.Params.Model = nil
+		}
+		result, err := fn(p, req)
+		if err == nil {
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return result, err
+		}
+		if committed != nil && committed() {
+			return result, fmt.Errorf("%s: %w", p.Name(), err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+		if i+1 < len(providers) {
+			log.Printf("LLM provider %s failed, falling back to %s: %v", p.Name(), providers[i+1].Name(), err)
+		}
+	}
+	return ChatResult{}, errors.Join(errs...)
+}
+
+// Chat implements Provider
+func (f *FallbackProvider) Chat(ctx context.Context, req ChatRequest) (ChatResult, error) {
+	return f.try(ctx, req, func(p Provider, req ChatRequest) (ChatResult, error) {
+		return p.Chat(ctx, req)
+	}, nil)
+}
+
+// ChatStream implements StreamingProvider. A provider that fails after emitting
+// part of its reply ends the request with its error: the next provider's reply
+// would be appended to the partial one. The turn is then retried from the start
+// per the retry policy.
+func (f *FallbackProvider) ChatStream(ctx context.Context, req ChatRequest, onDelta func(string)) (ChatResult, error) {
+	streamed := false
+	emit := func(delta string) {
+		streamed = true
+		onDelta(delta)
+	}
+	return f.try(ctx, req, func(p Provider, req ChatRequest) (ChatResult, error) {
+		if streamer, ok := p.(StreamingProvider); ok {
+			return streamer.ChatStream(ctx, req, emit)
+		}
+		result, err := p.Chat(ctx, req)
+		if err == nil {
+			emit(result.Content)
+		}
+		return result, err
+	}, func() bool { return streamed })
+}
+
+// ChatWithTools implements ToolCallingProvider
+func (f *FallbackProvider) ChatWithTools(ctx context.Context, req ChatRequest) (ChatResult, error) {
+	return f.try(ctx, req, func(p Provider, req ChatRequest) (ChatResult, error) {
+		if caller, ok := p.(ToolCallingProvider); ok {
+			return caller.ChatWithTools(ctx, req)
+		}
+		return p.Chat(ctx, req)
+	}, nil)
+}
+
+// CountTokens implements TokenCounter with the first provider in the chain that can count
+func (f *FallbackProvider) CountTokens(ctx context.Context, model, text string) (int, error) {
+	for _, p := range f.providers {
+		if counter, ok := p.(TokenCounter); ok {
+			return counter.CountTokens(ctx, model, text)
+		}
+	}
+	return 0, fmt.Errorf("no provider in the chain can count tokens")
+}
+
+// ListModels implements ModelLister with the models of every provider in the chain
+// that can list them. Only the first provider's default model is the default.
+func (f *FallbackProvider) ListModels(ctx context.Context) ([]models.ModelInfo, error) {
+	var list []models.ModelInfo
+	owners := map[string]int{}
+	var errs []error
+	for i, p := range f.providers {
+		lister, ok := p.(ModelLister)
+		if !ok {
+			continue
+		}
+		served, err := lister.ListModels(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+			continue
+		}
+		for _, m := range served {
+			if _, seen := owners[m.ID]; seen {
+				continue
+			}
+			owners[m.ID] = i
+			m.Default = m.Default && i == 0
+			list = append(list, m)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.listed = time.Now()
+	if list == nil && errs != nil {
+		return nil, errors.Join(errs...)
+	}
+	f.owners = owners
+	return list, nil
+}
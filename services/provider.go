@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"chat-app/models"
+)
+
+// Provider is implemented by every LLM backend the chat app can talk to
+type Provider interface {
+	// Name returns the registry name of the provider (e.g. "vllm")
+	Name() string
+	// Chat sends the conversation history plus a new user message and returns the reply.
+	// req.Tools is ignored. Cancelling ctx aborts the request.
+	Chat(ctx context.Context, req ChatRequest) (ChatResult, error)
+}
+
+// ChatResult is one model turn: reply text, tool calls, or both, plus what the
+// backend reported about producing it
+type ChatResult struct {
+	Content   string
+	ToolCalls []models.ToolCall
+	// Model is the model that answered, as named by the backend
+	Model string
+	// FinishReason is why generation stopped: "stop", "length" or "tool_calls"
+	FinishReason string
+	// Usage is nil if the backend did not report token counts
+	Usage *models.Usage
+}
+
+// ChatRequest is everything a provider needs to produce the next assistant reply
+type ChatRequest struct {
+	// System is the system prompt; empty means none
+	System      string
+	Messages    []models.Message
+	UserMessage string
+	// Params overrides the provider's default model and sampling settings
+	Params models.ModelParams
+	// Tools are offered to the model by ChatWithTools. Without tools, tool call
+	// and result messages in the history are sent as plain text.
+	Tools []ToolSpec
+	// Turn holds the tool calls and results produced so far while answering UserMessage
+	Turn []models.Message
+}
+
+// ProviderFactory builds a provider from its configuration
+type ProviderFactory func(cfg ProviderConfig) (Provider, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]ProviderFactory{}
+)
+
+// RegisterProvider makes a provider available under the given name.
+// Backends call it from an init function so main.go never has to know about them.
+func RegisterProvider(name string, factory ProviderFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("services: provider %q registered twice", name))
+	}
+	registry[name] = factory
+}
+
+// ProviderNames returns the names of all registered providers, sorted
+func ProviderNames() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewProvider builds the provider selected by cfg. If cfg has fallbacks, the
+// result is a FallbackProvider that tries them in order after cfg's own backend.
+func NewProvider(cfg ProviderConfig) (Provider, error) {
+	primary, err := newProvider(cfg)
+	if err != nil || len(cfg.Fallbacks) == 0 {
+		return primary, err
+	}
+	chain := []Provider{primary}
+	for _, fallback := range cfg.Fallbacks {
+		p, err := newProvider(fallback)
+		if err != nil {
+			return nil, fmt.Errorf("fallback %s: %w", fallback.Name, err)
+		}
+		chain = append(chain, p)
+	}
+	return NewFallbackProvider(chain...), nil
+}
+
+func newProvider(cfg ProviderConfig) (Provider, error) {
+	registryMu.RLock()
+	factory, ok := registry[cfg.Name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown LLM provider %q (available: %v)", cfg.Name, ProviderNames())
+	}
+	return factory(cfg)
+}
+
+// ProviderConfig selects an LLM backend and carries its backend-specific options
+type ProviderConfig struct {
+	Name    string            `json:"provider"`
+	Options map[string]string `json:"options"`
+	// Fallbacks are tried in order when a request to this backend fails, e.g. a
+	// second vLLM endpoint with its own base_url
+	Fallbacks []ProviderConfig `json:"fallbacks,omitempty"`
+
+	// envPrefix, if set, makes Option read envPrefix plus the upper-cased option
+	// key (e.g. FALLBACK_VLLM_BASE_URL) before the backend's usual variable
+	envPrefix string
+	// prefixedEnvOnly keeps Option from reading the backend's usual variables,
+	// which configure the primary backend
+	prefixedEnvOnly bool
+}
+
+// Option returns the option stored under key, falling back to the environment and
+// finally to def. Only fallbacks read from LLM_FALLBACKS have prefixed variables;
+// the envVar variable is read unless the fallback repeats the primary's backend.
+func (c ProviderConfig) Option(key, envVar, def string) string {
+	if v, ok := c.Options[key]; ok && v != "" {
+		return v
+	}
+	if c.envPrefix != "" {
+		if v := os.Getenv(c.envPrefix + strings.ToUpper(key)); v != "" {
+			return v
+		}
+	}
+	if envVar != "" && !c.prefixedEnvOnly {
+		if v := os.Getenv(envVar); v != "" {
+			return v
+		}
+	}
+	return def
+}
+
+// LoadProviderConfig reads the provider configuration.
+// If LLM_CONFIG_FILE is set it is parsed as JSON first; LLM_PROVIDER overrides
+// the provider name from the file and LLM_FALLBACKS, a comma-separated list of
+// provider names configured from the environment, overrides its fallbacks.
+// Each of those reads FALLBACK_<NAME>_<OPTION> variables first, and a fallback on
+// the primary's backend reads only those, so it cannot reach the same endpoint.
+// A name may appear only once in LLM_FALLBACKS. The default provider is "vllm".
+func LoadProviderConfig() (ProviderConfig, error) {
+	var cfg ProviderConfig
+
+	if path := os.Getenv("LLM_CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to read LLM config file: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("failed to parse LLM config file: %w", err)
+		}
+	}
+
+	if name := os.Getenv("LLM_PROVIDER"); name != "" {
+		cfg.Name = name
+	}
+	if cfg.Name == "" {
+		cfg.Name = "vllm"
+	}
+	if v := os.Getenv("LLM_FALLBACKS"); v != "" {
+		cfg.Fallbacks = nil
+		seen := map[string]bool{}
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if seen[name] {
+				return cfg, fmt.Errorf("LLM_FALLBACKS lists %q twice; use LLM_CONFIG_FILE to configure several", name)
+			}
+			seen[name] = true
+			cfg.Fallbacks = append(cfg.Fallbacks, ProviderConfig{
+				Name:            name,
+				envPrefix:       fallbackEnvPrefix(name),
+				prefixedEnvOnly: name == cfg.Name,
+			})
+		}
+	}
+	return cfg, nil
+}
+
+// fallbackEnvPrefix is the prefix of the variables configuring a fallback listed in
+// LLM_FALLBACKS, e.g. FALLBACK_VLLM_
+func fallbackEnvPrefix(name string) string {
+	return "FALLBACK_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name) + "_"
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoadProviderConfigFallbackEnv(t *testing.T) {
+	t.Setenv("LLM_CONFIG_FILE", "")
+	t.Setenv("LLM_PROVIDER", "vllm")
+	t.Setenv("LLM_FALLBACKS", "vllm, anthropic")
+	t.Setenv("VLLM_BASE_URL", "http://gpu-1:5000")
+	t.Setenv("VLLM_MODEL", "primary-model")
+	t.Setenv("FALLBACK_VLLM_BASE_URL", "http://gpu-2:5000")
+	t.Setenv("ANTHROPIC_API_KEY", "shared-key")
+	t.Setenv("ANTHROPIC_MODEL", "shared-model")
+	t.Setenv("FALLBACK_ANTHROPIC_MODEL", "fallback-model")
+
+	cfg, err := LoadProviderConfig()
+	if err != nil {
+		t.Fatalf("LoadProviderConfig: %v", err)
+	}
+	if len(cfg.Fallbacks) != 2 {
+		t.Fatalf("got %d fallbacks, want 2", len(cfg.Fallbacks))
+	}
+	vllm, anthropic := cfg.Fallbacks[0], cfg.Fallbacks[1]
+
+	tests := []struct {
+		name   string
+		cfg    ProviderConfig
+		key    string
+		envVar string
+		want   string
+	}{
+		{"primary reads the usual variable", cfg, "base_url", "VLLM_BASE_URL", "http://gpu-1:5000"},
+		{"prefixed variable", vllm, "base_url", "VLLM_BASE_URL", "http://gpu-2:5000"},
+		{"same backend ignores the primary's variables", vllm, "model", "VLLM_MODEL", "default"},
+		{"prefixed variable overrides", anthropic, "model", "ANTHROPIC_MODEL", "fallback-model"},
+		{"other backend reads the usual variable", anthropic, "api_key", "ANTHROPIC_API_KEY", "shared-key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.Option(tt.key, tt.envVar, "default"); got != tt.want {
+				t.Errorf("Option(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadProviderConfigRejectsRepeatedFallback(t *testing.T) {
+	t.Setenv("LLM_CONFIG_FILE", "")
+	t.Setenv("LLM_PROVIDER", "vllm")
+	t.Setenv("LLM_FALLBACKS", "anthropic,anthropic")
+	if cfg, err := LoadProviderConfig(); err == nil {
+		t.Errorf("LoadProviderConfig = %+v, want error", cfg.Fallbacks)
+	}
+}
+
+func TestFallbackEnvPrefix(t *testing.T) {
+	got := []string{fallbackEnvPrefix("vllm"), fallbackEnvPrefix("my-backend2")}
+	want := []string{"FALLBACK_VLLM_", "FALLBACK_MY_BACKEND2_"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fallbackEnvPrefix = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatusError is returned when an LLM backend answers with an HTTP error status
+type StatusError struct {
+	// Backend names the API, e.g. "vLLM"
+	Backend    string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s API error (status %d): %s", e.Backend, e.StatusCode, e.Body)
+}
+
+// RetryPolicy controls how a failed model request is retried
+type RetryPolicy struct {
+	// MaxAttempts is how often a request is tried in total; 1 disables retries
+	MaxAttempts int
+	// BaseInterval is the wait before the first retry, multiplied by BackoffFactor
+	// for each further retry up to MaxInterval
+	BaseInterval  time.Duration
+	MaxInterval   time.Duration
+	BackoffFactor float64
+	// RetryStatuses are the HTTP statuses worth retrying: classes such as "5xx"
+	// or single codes such as "429". Network errors are always retried.
+	RetryStatuses []string
+}
+
+// LoadRetryPolicy reads the retry policy from LLM_RETRY_MAX_ATTEMPTS,
+// LLM_RETRY_BASE_INTERVAL, LLM_RETRY_MAX_INTERVAL, LLM_RETRY_BACKOFF and
+// LLM_RETRY_STATUSES. Invalid values are logged and ignored.
+func LoadRetryPolicy() RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts:   3,
+		BaseInterval:  time.Second,
+		MaxInterval:   30 * time.Second,
+		BackoffFactor: 2,
+		RetryStatuses: []string{"5xx", "429"},
+	}
+	if v := os.Getenv("LLM_RETRY_MAX_ATTEMPTS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			policy.MaxAttempts = n
+		} else {
+			log.Printf("Ignoring invalid LLM_RETRY_MAX_ATTEMPTS %q", v)
+		}
+	}
+	for envVar, dst := range map[string]*time.Duration{
+		"LLM_RETRY_BASE_INTERVAL": &policy.BaseInterval,
+		"LLM_RETRY_MAX_INTERVAL":  &policy.MaxInterval,
+	} {
+		if v := os.Getenv(envVar); v != "" {
+			if d, err := time.ParseDuration(v); err == nil && d > 0 {
+				*dst = d
+			} else {
+				log.Printf("Ignoring invalid %s %q", envVar, v)
+			}
+		}
+	}
+	if v := os.Getenv("LLM_RETRY_BACKOFF"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 1 {
+			policy.BackoffFactor = f
+		} else {
+			log.Printf("Ignoring invalid LLM_RETRY_BACKOFF %q", v)
+		}
+	}
+	if v, ok := os.LookupEnv("LLM_RETRY_STATUSES"); ok {
+		var statuses []string
+		for _, status := range strings.Split(v, ",") {
+			status = strings.ToLower(strings.TrimSpace(status))
+			if status == "" {
+				continue
+			}
+			if !validStatusPattern(status) {
+				log.Printf("Ignoring invalid LLM_RETRY_STATUSES entry %q", status)
+				continue
+			}
+			statuses = append(statuses, status)
+		}
+		policy.RetryStatuses = statuses
+	}
+	return policy
+}
+
+// validStatusPattern accepts a status class such as "5xx" or a code such as "429"
+func validStatusPattern(pattern string) bool {
+	if len(pattern) != 3 || pattern[0] < '1' || pattern[0] > '5' {
+		return false
+	}
+	if pattern[1:] == "xx" {
+		return true
+	}
+	_, err := strconv.Atoi(pattern)
+	return err == nil
+}
+
+// Retryable reports whether a request that failed with err is worth trying again.
+// Errors joined by FallbackProvider are retryable if any of them is.
+func (p RetryPolicy) Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			if p.Retryable(e) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		code := strconv.Itoa(statusErr.StatusCode)
+		for _, pattern := range p.RetryStatuses {
+			if pattern == code || (strings.HasSuffix(pattern, "xx") && pattern[0] == code[0]) {
+				return true
+			}
+		}
+		return false
+	}
+	// A connection that failed or dropped mid-response
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	policy := RetryPolicy{RetryStatuses: []string{"5xx", "429"}}
+	status := func(code int) error {
+		return &StatusError{Backend: "vLLM", StatusCode: code, Body: "error"}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"server error class", status(503), true},
+		{"listed code", status(429), true},
+		{"client error", status(400), false},
+		{"unlisted code", status(404), false},
+		{"wrapped status", fmt.Errorf("vLLM: %w", status(502)), true},
+		{"network error", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{"dropped mid-response", fmt.Errorf("reading stream: %w", io.ErrUnexpectedEOF), true},
+		{"canceled", context.Canceled, false},
+		{"plain error", errors.New("empty response"), false},
+		{"joined with a retryable error", errors.Join(status(400), status(500)), true},
+		{"joined without a retryable error", errors.Join(status(400), errors.New("bad")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Retryable(tt.err); got != tt.want {
+				t.Errorf("Retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyRetryableNoStatuses(t *testing.T) {
+	policy := RetryPolicy{}
+	if policy.Retryable(&StatusError{StatusCode: 503}) {
+		t.Error("Retryable(503) = true with no RetryStatuses, want false")
+	}
+	if !policy.Retryable(io.ErrUnexpectedEOF) {
+		t.Error("Retryable(io.ErrUnexpectedEOF) = false, want network errors always retried")
+	}
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+)
+
+// StreamingProvider is implemented by providers that can emit a reply token by token
+type StreamingProvider interface {
+	Provider
+	// ChatStream behaves like Chat but calls onDelta for every text fragment as it
+	// arrives. It returns the full reply once the stream has finished.
+	ChatStream(ctx context.Context, req ChatRequest, onDelta func(string)) (ChatResult, error)
+}
+
+// readSSE parses a Server-Sent Events body and calls fn for every event.
+// Returning an error from fn stops reading and propagates the error.
+func readSSE(r io.Reader, fn func(event, data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var event string
+	var data []string
+	dispatch := func() error {
+		if len(data) == 0 {
+			event = ""
+			return nil
+		}
+		err := fn(event, strings.Join(data, "\n"))
+		event, data = "", nil
+		return err
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if err := dispatch(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, ":"):
+			// comment / keep-alive
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return dispatch()
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadSSE(t *testing.T) {
+	type event struct{ name, data string }
+	tests := []struct {
+		name string
+		body string
+		want []event
+	}{
+		{
+			name: "data only",
+			body: "data: one\n\ndata: two\n\n",
+			want: []event{{"", "one"}, {"", "two"}},
+		},
+		{
+			name: "named events",
+			body: "event: message_start\ndata: {}\n\nevent: ping\ndata: {}\n\n",
+			want: []event{{"message_start", "{}"}, {"ping", "{}"}},
+		},
+		{
+			name: "multi-line data is joined",
+			body: "data: first\ndata: second\n\n",
+			want: []event{{"", "first\nsecond"}},
+		},
+		{
+			name: "no space after colon",
+			body: "data:tight\n\n",
+			want: []event{{"", "tight"}},
+		},
+		{
+			name: "comments and blank lines are skipped",
+			body: ": keep-alive\n\n\n\ndata: x\n\n",
+			want: []event{{"", "x"}},
+		},
+		{
+			name: "event without data is dropped",
+			body: "event: ping\n\ndata: x\n\n",
+			want: []event{{"", "x"}},
+		},
+		{
+			name: "last event without trailing blank line",
+			body: "data: one\n\ndata: [DONE]",
+			want: []event{{"", "one"}, {"", "[DONE]"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []event
+			err := readSSE(strings.NewReader(tt.body), func(name, data string) error {
+				got = append(got, event{name, data})
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("readSSE: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadSSEStopsOnError(t *testing.T) {
+	stop := errors.New("stop")
+	calls := 0
+	err := readSSE(strings.NewReader("data: one\n\ndata: two\n\n"), func(_, _ string) error {
+		calls++
+		return stop
+	})
+	if err != stop {
+		t.Errorf("err = %v, want %v", err, stop)
+	}
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"chat-app/models"
+)
+
+// toolTimeout bounds a single tool execution
+const toolTimeout = 30 * time.Second
+
+// Tool is a function the model can call while answering
+type Tool interface {
+	// Name is how the model refers to the tool; letters, digits and underscores only
+	Name() string
+	// Description tells the model what the tool does and when to use it
+	Description() string
+	// Parameters is the JSON schema of the arguments object
+	Parameters() json.RawMessage
+	// Execute runs the tool with the model's arguments and returns the result shown to the model
+	Execute(ctx context.Context, arguments json.RawMessage) (string, error)
+}
+
+// ToolSpec describes a tool to the model
+type ToolSpec struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage
+}
+
+// ToolCallingProvider is implemented by providers whose models can call tools
+type ToolCallingProvider interface {
+	Provider
+	// ChatWithTools behaves like Chat but offers req.Tools to the model
+	ChatWithTools(ctx context.Context, req ChatRequest) (ChatResult, error)
+}
+
+var (
+	toolsMu      sync.RWMutex
+	toolRegistry = map[string]Tool{}
+)
+
+// RegisterTool makes a tool available under its name. Tools call it from an init function.
+func RegisterTool(tool Tool) {
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+	if _, exists := toolRegistry[tool.Name()]; exists {
+		panic(fmt.Sprintf("services: tool %q registered twice", tool.Name()))
+	}
+	toolRegistry[tool.Name()] = tool
+}
+
+// ToolNames returns the names of all registered tools, sorted
+func ToolNames() []string {
+	toolsMu.RLock()
+	defer toolsMu.RUnlock()
+	return toolNamesLocked()
+}
+
+// Toolbox is the set of tools offered to the model
+type Toolbox struct {
+	tools map[string]Tool
+	specs []ToolSpec
+}
+
+// NewToolbox builds a toolbox from registered tool names
+func NewToolbox(names []string) (*Toolbox, error) {
+	toolsMu.RLock()
+	defer toolsMu.RUnlock()
+	tb := &Toolbox{tools: make(map[string]Tool, len(names))}
+	for _, name := range names {
+		tool, ok := toolRegistry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown tool %q (available: %v)", name, toolNamesLocked())
+		}
+		tb.tools[name] = tool
+		tb.specs = append(tb.specs, ToolSpec{
+			Name:        tool.Name(),
+			Description: tool.Description(),
+			Parameters:  tool.Parameters(),
+		})
+	}
+	return tb, nil
+}
+
+func toolNamesLocked() []string {
+	names := make([]string, 0, len(toolRegistry))
+	for name := range toolRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadToolbox builds the toolbox named by TOOLS, a comma-separated list of tool
+// names, or "all" for every registered tool. Tool calling is off by default
+// because vLLM rejects tool requests unless started with a tool call parser.
+func LoadToolbox() (*Toolbox, error) {
+	v := strings.TrimSpace(os.Getenv("TOOLS"))
+	switch v {
+	case "", "none":
+		return NewToolbox(nil)
+	case "all":
+		return NewToolbox(ToolNames())
+	}
+	var names []string
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return NewToolbox(names)
+}
+
+// Specs returns the tools to offer the model
+func (t *Toolbox) Specs() []ToolSpec {
+	return t.specs
+}
+
+// Execute runs a tool call. Failures are returned as the result text so the model
+// can see what went wrong and try again or answer without the tool.
+func (t *Toolbox) Execute(ctx context.Context, call models.ToolCall) string {
+	tool, ok := t.tools[call.Name]
+	if !ok {
+		return fmt.Sprintf("error: unknown tool %q", call.Name)
+	}
+	args := json.RawMessage(call.Arguments)
+	if strings.TrimSpace(call.Arguments) == "" {
+		args = json.RawMessage("{}")
+	}
+	if !json.Valid(args) {
+		return "error: arguments are not valid JSON"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, toolTimeout)
+	defer cancel()
+	result, err := tool.Execute(ctx, args)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return result
+}
+
+// toolCallText renders a tool call message as plain text, for requests that offer no tools
+func toolCallText(msg models.Message) string {
+	var b strings.Builder
+	b.WriteString(msg.Content)
+	for _, call := range msg.ToolCalls {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "[called tool %s with %s]", call.Name, call.Arguments)
+	}
+	return b.String()
+}
+
+// toolResultText renders a tool result message as plain text, for requests that offer no tools
+func toolResultText(msg models.Message) string {
+	return "[tool result]\n" + msg.Content
+}
+
+// MessageText returns the text a message contributes to a prompt, including tool call arguments
+func MessageText(msg models.Message) string {
+	switch msg.Role {
+	case models.RoleToolCall:
+		return toolCallText(msg)
+	case models.RoleToolResult:
+		return toolResultText(msg)
+	default:
+		return msg.Content
+	}
+}
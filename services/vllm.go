@@ -2,29 +2,80 @@ package services
 
 import (
 	"bytes"
-	"chat-app/models"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
+
+	"chat-app/models"
+)
+
+const (
+	defaultVLLMModel       = "meta-llama/Meta-Llama-3.1-8B-Instruct"
+	defaultMaxTokens       = 4096
+	defaultVLLMTemperature = 0.7
 )
 
 type VLLMService struct {
-	baseURL string
-	client  *http.Client
+	pool         *VLLMPool
+	model        string
+	client       *http.Client
+	streamClient *http.Client
 }
 
 type VLLMMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string         `json:"role"`
+	Content    string         `json:"content"`
+	ToolCalls  []VLLMToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string         `json:"tool_call_id,omitempty"`
+}
+
+// VLLMToolCall is a function call made by the model
+type VLLMToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// VLLMTool declares a function the model may call
+type VLLMTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		Parameters  json.RawMessage `json:"parameters"`
+	} `json:"function"`
 }
 
 type VLLMRequest struct {
-	Model    string        `json:"model"`
-	Messages []VLLMMessage `json:"messages"`
-	MaxTokens int          `json:"max_tokens"`
-	Temperature float64    `json:"temperature"`
+	Model       string        `json:"model"`
+	Messages    []VLLMMessage `json:"messages"`
+	MaxTokens   int           `json:"max_tokens"`
+	Temperature float64       `json:"temperature"`
+	TopP        *float64      `json:"top_p,omitempty"`
+	Stop        []string      `json:"stop,omitempty"`
+	Stream      bool          `json:"stream,omitempty"`
+	// StreamOptions asks for a final chunk with token usage when streaming
+	StreamOptions *VLLMStreamOptions `json:"stream_options,omitempty"`
+	Tools         []VLLMTool         `json:"tools,omitempty"`
+}
+
+// VLLMStreamOptions configures a stream=true request
+type VLLMStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// VLLMUsage is the token usage of a completion
+type VLLMUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 type VLLMResponse struct {
@@ -35,90 +86,415 @@ type VLLMResponse struct {
 	Choices []struct {
 		Index   int `json:"index"`
 		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
+			Role      string         `json:"role"`
+			Content   string         `json:"content"`
+			ToolCalls []VLLMToolCall `json:"tool_calls"`
 		} `json:"message"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	} `json:"usage"`
+	Usage *VLLMUsage `json:"usage"`
+}
+
+// VLLMStreamChunk is one chat.completion.chunk event of a stream=true response
+type VLLMStreamChunk struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Index int `json:"index"`
+		Delta struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	// Usage is only set on the final chunk, when requested with stream_options
+	Usage *VLLMUsage `json:"usage"`
+}
+
+func init() {
+	RegisterProvider("vllm", func(cfg ProviderConfig) (Provider, error) {
+		baseURLs := ParseBaseURLs(cfg.Option("base_url", "VLLM_BASE_URL", "http://localhost:5000"))
+		if len(baseURLs) == 0 {
+			return nil, fmt.Errorf("vllm provider requires at least one base URL")
+		}
+		poolCfg := PoolConfig{Strategy: cfg.Option("lb_strategy", "VLLM_LB_STRATEGY", PoolLeastOutstanding)}
+		if poolCfg.Strategy != PoolLeastOutstanding && poolCfg.Strategy != PoolRoundRobin {
+			return nil, fmt.Errorf("unknown vLLM load balancing strategy %q", poolCfg.Strategy)
+		}
+		if v := cfg.Option("health_interval", "VLLM_HEALTH_INTERVAL", ""); v != "" {
+			interval, err := time.ParseDuration(v)
+			if err != nil || interval <= 0 {
+				return nil, fmt.Errorf("invalid vLLM health interval %q", v)
+			}
+			poolCfg.HealthInterval = interval
+		}
+		model := cfg.Option("model", "VLLM_MODEL", defaultVLLMModel)
+		return NewVLLMService(NewVLLMPool(baseURLs, poolCfg), model), nil
+	})
 }
 
-func NewVLLMService(baseURL string) *VLLMService {
+// NewVLLMService creates a vLLM provider that sends requests to the replicas of pool
+func NewVLLMService(pool *VLLMPool, model string) *VLLMService {
+	if model == "" {
+		model = defaultVLLMModel
+	}
 	return &VLLMService{
-		baseURL: baseURL,
+		pool:  pool,
+		model: model,
 		client: &http.Client{
 			Timeout: 120 * time.Second,
 		},
+		// Streams can outlive the regular timeout; they are bounded by the caller's context
+		streamClient: &http.Client{},
 	}
 }
 
-func (s *VLLMService) Chat(messages []models.Message, userMessage string) (string, error) {
+// Name implements Provider
+func (s *VLLMService) Name() string {
+	return "vllm"
+}
+
+// newRequest converts a ChatRequest to a vLLM request, applying the conversation's parameters
+func (s *VLLMService) newRequest(chatReq ChatRequest) VLLMRequest {
 	// Convert message history to vLLM format
-	vllmMessages := make([]VLLMMessage, 0, len(messages)+1)
+	vllmMessages := make([]VLLMMessage, 0, len(chatReq.Messages)+2)
 
-	// Add conversation history
-	for _, msg := range messages {
+	// The system prompt goes first as a system message
+	if chatReq.System != "" {
 		vllmMessages = append(vllmMessages, VLLMMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
+			Role:    "system",
+			Content: chatReq.System,
 		})
 	}
 
-	// Add new user message
+	// Add conversation history, the new user message, then this turn's tool calls and results
+	withTools := len(chatReq.Tools) > 0
+	calls := make(map[string]bool)
+	appendMessage := func(msg models.Message) {
+		if m, ok := vllmMessage(msg, withTools, calls); ok {
+			vllmMessages = append(vllmMessages, m)
+		}
+	}
+	for _, msg := range chatReq.Messages {
+		appendMessage(msg)
+	}
 	vllmMessages = append(vllmMessages, VLLMMessage{
 		Role:    "user",
-		Content: userMessage,
+		Content: chatReq.UserMessage,
 	})
+	for _, msg := range chatReq.Turn {
+		appendMessage(msg)
+	}
 
-	// Prepare request
+	params := chatReq.Params
 	reqBody := VLLMRequest{
-		Model:       "meta-llama/Meta-Llama-3.1-8B-Instruct",
+		Model:       s.model,
 		Messages:    vllmMessages,
-		MaxTokens:   4096,
-		Temperature: 0.7,
+		MaxTokens:   defaultMaxTokens,
+		Temperature: defaultVLLMTemperature,
+		TopP:        params.TopP,
+		Stop:        params.StopSequences,
+	}
+	if params.Model != nil && *params.Model != "" {
+		reqBody.Model = *params.Model
+	}
+	if params.MaxTokens != nil {
+		reqBody.MaxTokens = *params.MaxTokens
+	}
+	if params.Temperature != nil {
+		reqBody.Temperature = *params.Temperature
+	}
+	for _, spec := range chatReq.Tools {
+		tool := VLLMTool{Type: "function"}
+		tool.Function.Name = spec.Name
+		tool.Function.Description = spec.Description
+		tool.Function.Parameters = spec.Parameters
+		reqBody.Tools = append(reqBody.Tools, tool)
+	}
+	return reqBody
+}
+
+// vllmMessage converts a stored message to the chat completions format. Without
+// tools, tool calls and results become plain text. A tool result whose call is
+// not in the request (e.g. trimmed from the context window) is dropped.
+func vllmMessage(msg models.Message, withTools bool, calls map[string]bool) (VLLMMessage, bool) {
+	switch msg.Role {
+	case models.RoleToolCall:
+		if !withTools {
+			return VLLMMessage{Role: "assistant", Content: toolCallText(msg)}, true
+		}
+		m := VLLMMessage{Role: "assistant", Content: msg.Content}
+		for _, call := range msg.ToolCalls {
+			tc := VLLMToolCall{ID: call.ID, Type: "function"}
+			tc.Function.Name = call.Name
+			tc.Function.Arguments = call.Arguments
+			m.ToolCalls = append(m.ToolCalls, tc)
+			calls[call.ID] = true
+		}
+		return m, true
+	case models.RoleToolResult:
+		if !withTools {
+			return VLLMMessage{Role: "user", Content: toolResultText(msg)}, true
+		}
+		if msg.ToolCallID == nil || !calls[*msg.ToolCallID] {
+			return VLLMMessage{}, false
+		}
+		return VLLMMessage{Role: "tool", Content: msg.Content, ToolCallID: *msg.ToolCallID}, true
+	default:
+		return VLLMMessage{Role: msg.Role, Content: msg.Content}, true
+	}
+}
+
+// Chat implements Provider; req.Tools is ignored
+func (s *VLLMService) Chat(ctx context.Context, chatReq ChatRequest) (ChatResult, error) {
+	chatReq.Tools = nil
+	return s.chat(ctx, chatReq)
+}
+
+// ChatWithTools implements ToolCallingProvider. vLLM must be started with
+// --enable-auto-tool-choice and a --tool-call-parser matching the model.
+func (s *VLLMService) ChatWithTools(ctx context.Context, chatReq ChatRequest) (ChatResult, error) {
+	return s.chat(ctx, chatReq)
+}
+
+// chat sends a non-streaming request, offering req.Tools if there are any
+func (s *VLLMService) chat(ctx context.Context, chatReq ChatRequest) (ChatResult, error) {
+	vllmResp, err := s.complete(ctx, s.newRequest(chatReq))
+	if err != nil {
+		return ChatResult{}, err
+	}
+	return vllmResult(vllmResp), nil
+}
+
+// vllmResult converts the first choice of a response, with the response's usage
+func vllmResult(resp VLLMResponse) ChatResult {
+	choice := resp.Choices[0]
+	result := ChatResult{
+		Content:      choice.Message.Content,
+		Model:        resp.Model,
+		FinishReason: choice.FinishReason,
+		Usage:        resp.Usage.toModel(),
+	}
+	for _, tc := range choice.Message.ToolCalls {
+		result.ToolCalls = append(result.ToolCalls, models.ToolCall{
+			ID:        tc.ID,
+			Name:      tc.Function.Name,
+			Arguments: tc.Function.Arguments,
+		})
+	}
+	return result
+}
+
+// toModel converts reported usage; nil stays nil
+func (u *VLLMUsage) toModel() *models.Usage {
+	if u == nil {
+		return nil
+	}
+	return &models.Usage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
 	}
+}
+
+// complete sends a non-streaming chat completion request
+func (s *VLLMService) complete(ctx context.Context, reqBody VLLMRequest) (VLLMResponse, error) {
+	var vllmResp VLLMResponse
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return vllmResp, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
 	// Make API request to vLLM
-	url := fmt.Sprintf("%s/v1/chat/completions", s.baseURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	replica := s.pool.acquire()
+	defer replica.release()
+	url := fmt.Sprintf("%s/v1/chat/completions", replica.baseURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return vllmResp, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request to vLLM: %w", err)
+		s.pool.eject(replica, err)
+		return vllmResp, fmt.Errorf("failed to send request to vLLM: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return vllmResp, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("vLLM API error (status %d): %s", resp.StatusCode, string(body))
+		statusErr := &StatusError{Backend: "vLLM", StatusCode: resp.StatusCode, Body: string(body)}
+		s.pool.eject(replica, statusErr)
+		return vllmResp, statusErr
 	}
 
-	var vllmResp VLLMResponse
 	if err := json.Unmarshal(body, &vllmResp); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
+		return vllmResp, fmt.Errorf("failed to parse response: %w", err)
 	}
 
 	if len(vllmResp.Choices) == 0 {
-		return "", fmt.Errorf("no response from vLLM")
+		return vllmResp, fmt.Errorf("no response from vLLM")
+	}
+
+	return vllmResp, nil
+}
+
+// ChatStream implements StreamingProvider using vLLM's stream=true chat completions
+func (s *VLLMService) ChatStream(ctx context.Context, chatReq ChatRequest, onDelta func(string)) (ChatResult, error) {
+	chatReq.Tools = nil
+	reqBody := s.newRequest(chatReq)
+	reqBody.Stream = true
+	reqBody.StreamOptions = &VLLMStreamOptions{IncludeUsage: true}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return ChatResult{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	replica := s.pool.acquire()
+	defer replica.release()
+	url := fmt.Sprintf("%s/v1/chat/completions", replica.baseURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return ChatResult{}, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	return vllmResp.Choices[0].Message.Content, nil
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := s.streamClient.Do(req)
+	if err != nil {
+		s.pool.eject(replica, err)
+		return ChatResult{}, fmt.Errorf("failed to send request to vLLM: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		statusErr := &StatusError{Backend: "vLLM", StatusCode: resp.StatusCode, Body: string(body)}
+		s.pool.eject(replica, statusErr)
+		return ChatResult{}, statusErr
+	}
+
+	var result ChatResult
+	var full strings.Builder
+	err = readSSE(resp.Body, func(_, data string) error {
+		if data == "[DONE]" {
+			return io.EOF
+		}
+		var chunk VLLMStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("failed to parse stream chunk: %w", err)
+		}
+		if chunk.Model != "" {
+			result.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			result.Usage = chunk.Usage.toModel()
+		}
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != nil {
+				result.FinishReason = *choice.FinishReason
+			}
+			if choice.Delta.Content == "" {
+				continue
+			}
+			full.WriteString(choice.Delta.Content)
+			onDelta(choice.Delta.Content)
+		}
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return ChatResult{}, fmt.Errorf("failed to read vLLM stream: %w", err)
+	}
+
+	if full.Len() == 0 {
+		return ChatResult{}, fmt.Errorf("no response from vLLM")
+	}
+	result.Content = full.String()
+	return result, nil
+}
+
+// VLLMTokenizeResponse is the response of vLLM's /tokenize endpoint
+type VLLMTokenizeResponse struct {
+	Count       int `json:"count"`
+	MaxModelLen int `json:"max_model_len"`
+}
+
+// CountTokens implements TokenCounter using vLLM's /tokenize endpoint
+func (s *VLLMService) CountTokens(ctx context.Context, model, text string) (int, error) {
+	if model == "" {
+		model = s.model
+	}
+	jsonData, err := json.Marshal(map[string]string{"model": model, "prompt": text})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	replica := s.pool.acquire()
+	defer replica.release()
+	url := fmt.Sprintf("%s/tokenize", replica.baseURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.pool.eject(replica, err)
+		return 0, fmt.Errorf("failed to send request to vLLM: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		s.pool.eject(replica, &StatusError{Backend: "vLLM", StatusCode: resp.StatusCode, Body: string(body)})
+		return 0, fmt.Errorf("vLLM tokenize error (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	var tokResp VLLMTokenizeResponse
+	if err := json.Unmarshal(body, &tokResp); err != nil {
+		return 0, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return tokResp.Count, nil
+}
+
+// ListModels implements ModelLister with the models served by any replica
+func (s *VLLMService) ListModels(ctx context.Context) ([]models.ModelInfo, error) {
+	var list []models.ModelInfo
+	seen := map[string]bool{}
+	var lastErr error
+	for _, r := range s.pool.replicas {
+		served, err := s.pool.listModels(ctx, r)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, m := range served.Data {
+			if seen[m.ID] {
+				continue
+			}
+			seen[m.ID] = true
+			list = append(list, models.ModelInfo{
+				ID:               m.ID,
+				Provider:         s.Name(),
+				MaxContextLength: m.MaxModelLen,
+				Default:          m.ID == s.model,
+			})
+		}
+	}
+	if list == nil && lastErr != nil {
+		return nil, fmt.Errorf("failed to list vLLM models: %w", lastErr)
+	}
+	return list, nil
 }
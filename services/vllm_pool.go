@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// PoolLeastOutstanding sends each request to the replica with the fewest requests in flight
+	PoolLeastOutstanding = "least_outstanding"
+	// PoolRoundRobin sends requests to the replicas in turn
+	PoolRoundRobin = "round_robin"
+
+	defaultHealthInterval = 10 * time.Second
+	healthProbeTimeout    = 5 * time.Second
+)
+
+// PoolConfig configures a VLLMPool
+type PoolConfig struct {
+	// Strategy is PoolLeastOutstanding (default) or PoolRoundRobin
+	Strategy string
+	// HealthInterval is how often replicas are probed
+	HealthInterval time.Duration
+}
+
+// ParseBaseURLs splits a comma-separated list of base URLs
+func ParseBaseURLs(list string) []string {
+	var urls []string
+	for _, u := range strings.Split(list, ",") {
+		if u = strings.TrimRight(strings.TrimSpace(u), "/"); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// vllmReplica is one vLLM server of a pool
+type vllmReplica struct {
+	baseURL     string
+	outstanding atomic.Int64
+	healthy     atomic.Bool
+}
+
+// VLLMPool spreads requests across vLLM replicas serving the same model. Replicas
+// are probed in the background and ejected while /health or /v1/models fails, or
+// after a request to them fails to connect or gets a 5xx answer, until a probe
+// succeeds again. If every replica is ejected, all of them are tried rather than
+// failing outright.
+type VLLMPool struct {
+	replicas []*vllmReplica
+	strategy string
+	next     atomic.Uint64
+	client   *http.Client
+}
+
+// NewVLLMPool creates a pool of the given replicas. With more than one replica it
+// starts probing them every cfg.HealthInterval for the lifetime of the process.
+func NewVLLMPool(baseURLs []string, cfg PoolConfig) *VLLMPool {
+	p := &VLLMPool{
+		strategy: cfg.Strategy,
+		client:   &http.Client{Timeout: healthProbeTimeout},
+	}
+	if p.strategy == "" {
+		p.strategy = PoolLeastOutstanding
+	}
+	for _, u := range baseURLs {
+		r := &vllmReplica{baseURL: u}
+		r.healthy.Store(true)
+		p.replicas = append(p.replicas, r)
+	}
+	if len(p.replicas) > 1 {
+		interval := cfg.HealthInterval
+		if interval <= 0 {
+			interval = defaultHealthInterval
+		}
+		go p.probeLoop(interval)
+	}
+	return p
+}
+
+// BaseURLs returns the base URLs of all replicas, healthy or not
+func (p *VLLMPool) BaseURLs() []string {
+	urls := make([]string, len(p.replicas))
+	for i, r := range p.replicas {
+		urls[i] = r.baseURL
+	}
+	return urls
+}
+
+// acquire picks the replica for a request and counts the request against it.
+// The caller must release the replica once the request is over.
+func (p *VLLMPool) acquire() *vllmReplica {
+	candidates := make([]*vllmReplica, 0, len(p.replicas))
+	for _, r := range p.replicas {
+		if r.healthy.Load() {
+			candidates = append(candidates, r)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = p.replicas
+	}
+
+	var picked *vllmReplica
+	start := int(p.next.Add(1) % uint64(len(candidates)))
+	switch p.strategy {
+	case PoolRoundRobin:
+		picked = candidates[start]
+	default:
+		// Ties go to the replica after the last one picked, so idle replicas share the load
+		for i := range candidates {
+			r := candidates[(start+i)%len(candidates)]
+			if picked == nil || r.outstanding.Load() < picked.outstanding.Load() {
+				picked = r
+			}
+		}
+	}
+	picked.outstanding.Add(1)
+	return picked
+}
+
+// release ends a request counted by acquire
+func (r *vllmReplica) release() {
+	r.outstanding.Add(-1)
+}
+
+// eject takes a replica out of rotation after a request to it failed in a way that
+// points at the replica rather than the request. The next successful probe brings
+// it back.
+func (p *VLLMPool) eject(r *vllmReplica, err error) {
+	if len(p.replicas) == 1 || !replicaFailure(err) {
+		return
+	}
+	if r.healthy.Swap(false) {
+		log.Printf("Ejecting vLLM replica %s: %v", r.baseURL, err)
+	}
+}
+
+// replicaFailure reports whether err means the replica is in trouble: the connection
+// could not be made or was dropped, or the replica answered with a 5xx status.
+// Cancellations, timeouts, 4xx answers and requests that could not be built are
+// failures of the request.
+func replicaFailure(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return !opErr.Timeout()
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// probeLoop probes every replica each interval
+func (p *VLLMPool) probeLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		var wg sync.WaitGroup
+		for _, r := range p.replicas {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := p.probe(r)
+				switch {
+				case err != nil && r.healthy.Swap(false):
+					log.Printf("Ejecting vLLM replica %s: %v", r.baseURL, err)
+				case err == nil && !r.healthy.Swap(true):
+					log.Printf("vLLM replica %s is healthy again", r.baseURL)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// probe checks that a replica is up and serves at least one model. Which models a
+// replica serves is not checked against the pool's default: requests name their
+// own model, and a replica serving another one is still healthy.
+func (p *VLLMPool) probe(r *vllmReplica) error {
+	ctx := context.Background()
+	if _, err := p.get(ctx, r.baseURL+"/health"); err != nil {
+		return err
+	}
+	list, err := p.listModels(ctx, r)
+	if err != nil {
+		return err
+	}
+	if len(list.Data) == 0 {
+		return fmt.Errorf("no models are served")
+	}
+	return nil
+}
+
+// listModels fetches /v1/models from a replica
+func (p *VLLMPool) listModels(ctx context.Context, r *vllmReplica) (VLLMModelList, error) {
+	var list VLLMModelList
+	body, err := p.get(ctx, r.baseURL+"/v1/models")
+	if err != nil {
+		return list, err
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return list, fmt.Errorf("failed to parse /v1/models: %w", err)
+	}
+	return list, nil
+}
+
+// get fetches url, failing on a non-200 status
+func (p *VLLMPool) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return body, nil
+}
+
+// VLLMModelList is the response of vLLM's /v1/models endpoint
+type VLLMModelList struct {
+	Data []struct {
+		ID          string `json:"id"`
+		MaxModelLen int    `json:"max_model_len"`
+	} `json:"data"`
+}
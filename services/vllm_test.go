@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestVLLM starts a vLLM stand-in that answers chat completions with body
+func newTestVLLM(t *testing.T, status int, body string) *VLLMService {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return NewVLLMService(NewVLLMPool([]string{srv.URL}, PoolConfig{}), "test-model")
+}
+
+func TestVLLMChatStream(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantDeltas  []string
+		wantContent string
+		wantFinish  string
+		wantModel   string
+		wantTokens  int
+		wantErr     bool
+	}{
+		{
+			name: "deltas, finish reason and usage",
+			body: `data: {"model":"m1","choices":[{"index":0,"delta":{"role":"assistant","content":""}}]}
+
+data: {"model":"m1","choices":[{"index":0,"delta":{"content":"Hel"}}]}
+
+data: {"model":"m1","choices":[{"index":0,"delta":{"content":"lo"},"finish_reason":"stop"}]}
+
+data: {"model":"m1","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}
+
+data: [DONE]
+
+`,
+			wantDeltas:  []string{"Hel", "lo"},
+			wantContent: "Hello",
+			wantFinish:  "stop",
+			wantModel:   "m1",
+			wantTokens:  7,
+		},
+		{
+			name: "nothing after [DONE] is read",
+			body: `data: {"choices":[{"index":0,"delta":{"content":"a"}}]}
+
+data: [DONE]
+
+data: {"choices":[{"index":0,"delta":{"content":"b"}}]}
+
+`,
+			wantDeltas:  []string{"a"},
+			wantContent: "a",
+		},
+		{
+			name: "stream without [DONE]",
+			body: `data: {"choices":[{"index":0,"delta":{"content":"a"},"finish_reason":"length"}]}
+
+`,
+			wantDeltas:  []string{"a"},
+			wantContent: "a",
+			wantFinish:  "length",
+		},
+		{
+			name:    "malformed chunk",
+			body:    "data: {not json\n\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty reply",
+			body:    "data: [DONE]\n\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestVLLM(t, http.StatusOK, tt.body)
+			var deltas []string
+			result, err := s.ChatStream(context.Background(), ChatRequest{UserMessage: "hi"}, func(d string) {
+				deltas = append(deltas, d)
+			})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ChatStream succeeded with %+v, want error", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ChatStream: %v", err)
+			}
+			if !reflect.DeepEqual(deltas, tt.wantDeltas) {
+				t.Errorf("deltas = %q, want %q", deltas, tt.wantDeltas)
+			}
+			if result.Content != tt.wantContent {
+				t.Errorf("Content = %q, want %q", result.Content, tt.wantContent)
+			}
+			if result.FinishReason != tt.wantFinish {
+				t.Errorf("FinishReason = %q, want %q", result.FinishReason, tt.wantFinish)
+			}
+			if result.Model != tt.wantModel {
+				t.Errorf("Model = %q, want %q", result.Model, tt.wantModel)
+			}
+			switch {
+			case tt.wantTokens == 0 && result.Usage != nil:
+				t.Errorf("Usage = %+v, want nil", result.Usage)
+			case tt.wantTokens != 0 && (result.Usage == nil || result.Usage.TotalTokens != tt.wantTokens):
+				t.Errorf("Usage = %+v, want %d total tokens", result.Usage, tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestVLLMChatStreamStatusError(t *testing.T) {
+	s := newTestVLLM(t, http.StatusServiceUnavailable, "overloaded")
+	_, err := s.ChatStream(context.Background(), ChatRequest{UserMessage: "hi"}, func(string) {
+		t.Error("onDelta called for a failed request")
+	})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("err = %v, want a StatusError", err)
+	}
+	if statusErr.StatusCode != http.StatusServiceUnavailable || !strings.Contains(statusErr.Body, "overloaded") {
+		t.Errorf("StatusError = %+v", statusErr)
+	}
+}
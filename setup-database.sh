@@ -90,12 +90,14 @@ if [ "$RUN_MIGRATIONS" = true ]; then
     echo ""
     print_info "Running database migrations..."
 
-    if psql -d "$DB_NAME" -f migrations/001_init.sql; then
-        print_success "Migrations completed successfully"
-    else
-        print_error "Migration failed"
-        exit 1
-    fi
+    for migration in migrations/*.sql; do
+        print_info "Applying $migration..."
+        if ! psql -v ON_ERROR_STOP=1 -d "$DB_NAME" -f "$migration"; then
+            print_error "Migration $migration failed"
+            exit 1
+        fi
+    done
+    print_success "Migrations completed successfully"
 
     echo ""
     print_info "Verifying tables..."
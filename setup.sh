@@ -154,13 +154,14 @@ fi
 
 # Run migrations
 print_info "Running database migrations..."
-if psql "$DB_NAME" < migrations/001_init.sql; then
-    print_success "Migrations completed"
-    psql "$DB_NAME" -c "\dt"
-else
-    print_error "Migration failed"
-    exit 1
-fi
+for migration in migrations/*.sql; do
+    if ! psql -v ON_ERROR_STOP=1 "$DB_NAME" < "$migration"; then
+        print_error "Migration $migration failed"
+        exit 1
+    fi
+done
+print_success "Migrations completed"
+psql "$DB_NAME" -c "\dt"
 
 echo ""
 echo "================================================"
@@ -0,0 +1,90 @@
+package workflows
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"chat-app/models"
+
+	"github.com/dbos-inc/dbos-transact-golang/dbos"
+	"github.com/google/uuid"
+)
+
+// branchToContinue returns the messages a new user message follows, root first.
+// Normally that is the conversation's active branch; when editing, it is the
+// branch leading up to the edited message, so the edit becomes its sibling.
+func (w *ChatWorkflows) branchToContinue(ctx context.Context, conv models.Conversation, editMessageID *uuid.UUID) ([]models.Message, error) {
+	leaf := conv.ActiveLeafID
+	if editMessageID != nil {
+		var role string
+		err := w.db.QueryRowContext(ctx,
+			"SELECT role, parent_id FROM messages WHERE id = $1 AND conversation_id = $2",
+			*editMessageID, conv.ID).Scan(&role, &leaf)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("message %s not found", *editMessageID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if role != models.RoleUser {
+			return nil, fmt.Errorf("only user messages can be edited")
+		}
+	}
+	return w.getBranch(ctx, leaf)
+}
+
+// getBranch returns the messages from the root of the conversation tree down to leaf
+func (w *ChatWorkflows) getBranch(ctx context.Context, leaf *uuid.UUID) ([]models.Message, error) {
+	if leaf == nil {
+		return nil, nil
+	}
+	rows, err := w.db.QueryContext(ctx,
+		models.BranchCTE+"SELECT "+models.MessageColumns+" FROM messages JOIN branch ON id = msg_id ORDER BY depth DESC",
+		*leaf)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		msg, err := models.ScanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// SwitchBranchInput contains the input for the SwitchBranch workflow
+type SwitchBranchInput struct {
+	ConversationID uuid.UUID
+	MessageID      uuid.UUID
+}
+
+// SwitchBranchWorkflow makes the branch containing a message the active one. The
+// conversation continues from the newest leaf below that message.
+func (w *ChatWorkflows) SwitchBranchWorkflow(ctx dbos.DBOSContext, input SwitchBranchInput) (models.Conversation, error) {
+	return dbos.RunAsStep(ctx, func(stepCtx context.Context) (models.Conversation, error) {
+		var leaf uuid.UUID
+		err := w.db.QueryRowContext(stepCtx,
+			`WITH RECURSIVE below(id, created_at) AS (
+			     SELECT id, created_at FROM messages WHERE id = $1 AND conversation_id = $2
+			     UNION ALL
+			     SELECT m.id, m.created_at FROM messages m JOIN below b ON m.parent_id = b.id
+			 )
+			 SELECT id FROM below b
+			 WHERE NOT EXISTS (SELECT 1 FROM messages c WHERE c.parent_id = b.id)
+			 ORDER BY created_at DESC, id DESC
+			 LIMIT 1`,
+			input.MessageID, input.ConversationID).Scan(&leaf)
+		if err != nil {
+			return models.Conversation{}, err
+		}
+		return models.ScanConversation(w.db.QueryRowContext(stepCtx,
+			"UPDATE conversations SET active_leaf_id = $1 WHERE id = $2 RETURNING "+models.ConversationColumns,
+			leaf, input.ConversationID))
+	})
+}
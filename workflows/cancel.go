@@ -0,0 +1,150 @@
+package workflows
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"chat-app/models"
+	"chat-app/services"
+
+	"github.com/dbos-inc/dbos-transact-golang/dbos"
+)
+
+// cancelPollInterval is how often a model turn checks whether its workflow has been
+// cancelled in DBOS, possibly by another instance
+const cancelPollInterval = time.Second
+
+// completion is the outcome of one model turn. Cancelled marks a reply cut short by
+// CancelReply; Content then holds the text produced until that point.
+type completion struct {
+	services.ChatResult
+	Cancelled bool
+}
+
+// generation is a reply being produced by a workflow running in this process
+type generation struct {
+	// cancel aborts the model turn in progress; nil between turns
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// generations tracks the replies being produced in this process, by workflow ID, so
+// their model turns can be aborted when the workflow is cancelled
+type generations struct {
+	mu      sync.Mutex
+	running map[string]*generation
+}
+
+func newGenerations() *generations {
+	return &generations{running: make(map[string]*generation)}
+}
+
+// begin registers a workflow as producing a reply. The returned function
+// unregisters it.
+func (g *generations) begin(workflowID string) func() {
+	g.mu.Lock()
+	g.running[workflowID] = &generation{}
+	g.mu.Unlock()
+	return func() {
+		g.mu.Lock()
+		delete(g.running, workflowID)
+		g.mu.Unlock()
+	}
+}
+
+// turnContext derives the context of a model turn, which cancel aborts. When ctx is
+// the context of a DBOS step, the turn is also aborted once the workflow is cancelled
+// in DBOS. The returned function must be called once the turn is over.
+func (g *generations) turnContext(ctx context.Context, workflowID string) (context.Context, func()) {
+	turnCtx, cancel := context.WithCancel(ctx)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	gen := g.running[workflowID]
+	if gen == nil {
+		return turnCtx, cancel
+	}
+	if gen.cancelled {
+		cancel()
+	}
+	gen.cancel = cancel
+	if stepCtx, ok := ctx.(dbos.DBOSContext); ok {
+		go g.watch(turnCtx, stepCtx, workflowID)
+	}
+	return turnCtx, func() {
+		g.mu.Lock()
+		gen.cancel = nil
+		g.mu.Unlock()
+		cancel()
+	}
+}
+
+// watch polls the status of a workflow until turnCtx is done and cancels the
+// workflow's turn once DBOS reports it cancelled. Inside a step, listing workflows
+// runs directly rather than as a step of its own.
+func (g *generations) watch(turnCtx context.Context, stepCtx dbos.DBOSContext, workflowID string) {
+	ticker := time.NewTicker(cancelPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-turnCtx.Done():
+			return
+		case <-ticker.C:
+		}
+		statuses, err := dbos.ListWorkflows(stepCtx,
+			dbos.WithWorkflowIDs([]string{workflowID}),
+			dbos.WithStatus([]dbos.WorkflowStatusType{dbos.WorkflowStatusCancelled}),
+			dbos.WithLoadInput(false),
+			dbos.WithLoadOutput(false))
+		if err == nil && len(statuses) > 0 {
+			g.cancel(workflowID)
+			return
+		}
+	}
+}
+
+// wasCancelled reports whether cancel was called for a workflow
+func (g *generations) wasCancelled(workflowID string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	gen := g.running[workflowID]
+	return gen != nil && gen.cancelled
+}
+
+// cancel stops the reply being produced by a workflow. It reports whether the
+// workflow is producing one in this process.
+func (g *generations) cancel(workflowID string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	gen := g.running[workflowID]
+	if gen == nil {
+		return false
+	}
+	gen.cancelled = true
+	if gen.cancel != nil {
+		gen.cancel()
+	}
+	return true
+}
+
+// CancelReply aborts the model request of a reply workflow that has been cancelled
+// in DBOS, if it runs in this process, without waiting for the workflow to poll its
+// status. The workflow then saves the text produced so far as a cancelled message.
+// It reports whether the workflow was producing a reply here.
+func (w *ChatWorkflows) CancelReply(workflowID string) bool {
+	return w.generations.cancel(workflowID)
+}
+
+// saveCancelled records the usage of a cancelled reply, charging it to caller, and
+// saves the reply with status cancelled. The workflow has been cancelled in DBOS,
+// which runs none of its further steps, so both happen directly rather than as
+// durable steps.
+func (w *ChatWorkflows) saveCancelled(ctx context.Context, caller Caller, usage []ModelUsage, msg models.Message) (models.Message, error) {
+	ctx = context.WithoutCancel(ctx)
+	if err := w.quotas.RecordTokens(ctx, caller, usage...); err != nil {
+		return models.Message{}, err
+	}
+	msg.Status = models.MessageCancelled
+	return w.saveMessage(ctx, msg)
+}
@@ -3,6 +3,8 @@ package workflows
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strings"
 	"time"
 
 	"chat-app/models"
@@ -10,26 +12,52 @@ import (
 
 	"github.com/dbos-inc/dbos-transact-golang/dbos"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 // ChatWorkflows contains DBOS workflows for chat operations
 type ChatWorkflows struct {
-	db          *sql.DB
-	vllmService *services.VLLMService
+	db             *sql.DB
+	provider       services.Provider
+	contextBuilder *services.ContextBuilder
+	// embedder is nil when no embedding model is configured; document retrieval is then disabled
+	embedder    *services.EmbeddingService
+	toolbox     *services.Toolbox
+	quotas      *Quotas
+	retry       services.RetryPolicy
+	streams     *StreamHub
+	generations *generations
 }
 
 // NewChatWorkflows creates a new ChatWorkflows instance
-func NewChatWorkflows(db *sql.DB, vllmService *services.VLLMService) *ChatWorkflows {
+func NewChatWorkflows(db *sql.DB, provider services.Provider, contextBuilder *services.ContextBuilder, embedder *services.EmbeddingService, toolbox *services.Toolbox, quotas *Quotas, retry services.RetryPolicy) *ChatWorkflows {
 	return &ChatWorkflows{
-		db:          db,
-		vllmService: vllmService,
+		db:             db,
+		provider:       provider,
+		contextBuilder: contextBuilder,
+		embedder:       embedder,
+		toolbox:        toolbox,
+		quotas:         quotas,
+		retry:          retry,
+		streams:        NewStreamHub(),
+		generations:    newGenerations(),
 	}
 }
 
+// Streams returns the hub that relays token deltas of running SendMessage workflows
+func (w *ChatWorkflows) Streams() *StreamHub {
+	return w.streams
+}
+
 // SendMessageInput contains the input for the SendMessage workflow
 type SendMessageInput struct {
 	ConversationID uuid.UUID
 	Content        string
+	// EditMessageID, if set, is a user message being edited: Content is saved as a
+	// new sibling of it, starting a new branch
+	EditMessageID *uuid.UUID
+	// Caller is charged for the tokens the reply uses
+	Caller Caller
 }
 
 // SendMessageOutput contains the output of the SendMessage workflow
@@ -43,109 +71,462 @@ type SendMessageOutput struct {
 func (w *ChatWorkflows) SendMessageWorkflow(ctx dbos.DBOSContext, input SendMessageInput) (SendMessageOutput, error) {
 	var output SendMessageOutput
 
-	// Step 1: Get existing messages for context (durable step)
-	messages, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) ([]models.Message, error) {
-		return w.getMessages(stepCtx, input.ConversationID)
+	// Step 1: Load the conversation's model settings (durable step)
+	conv, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (models.Conversation, error) {
+		return w.getConversation(stepCtx, input.ConversationID)
 	})
 	if err != nil {
 		return output, err
 	}
 
-	// Step 2: Save user message to database (durable step)
-	userMsg, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (models.Message, error) {
-		return w.saveMessage(stepCtx, input.ConversationID, "user", input.Content)
+	// Refuse the message if the caller is out of tokens for today (durable step)
+	if err := w.checkQuota(ctx, input.Caller); err != nil {
+		return output, err
+	}
+
+	// Step 2: Get the branch the new message continues, for context (durable step)
+	messages, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) ([]models.Message, error) {
+		return w.branchToContinue(stepCtx, conv, input.EditMessageID)
 	})
 	if err != nil {
 		return output, err
 	}
-	output.UserMessage = userMsg
+	var parentID *uuid.UUID
+	if len(messages) > 0 {
+		parentID = &messages[len(messages)-1].ID
+	}
 
-	// Step 3: Get AI response from vLLM (durable step - will retry on failure)
-	aiResponse, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (string, error) {
-		return w.vllmService.Chat(messages, input.Content)
-	})
+	// Steps 3-5: Resolve the system prompt, rolling summary and document excerpts (durable steps)
+	prompt, err := w.preparePrompt(ctx, conv, messages, input.Content)
 	if err != nil {
 		return output, err
 	}
 
-	// Step 4: Save assistant message to database (durable step)
-	assistantMsg, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (models.Message, error) {
-		return w.saveMessage(stepCtx, input.ConversationID, "assistant", aiResponse)
+	// Step 6: Save user message to database (durable step)
+	userMsg, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (models.Message, error) {
+		return w.saveMessage(stepCtx, models.Message{
+			ConversationID: input.ConversationID,
+			Role:           "user",
+			Content:        input.Content,
+			ParentID:       parentID,
+		})
 	})
 	if err != nil {
 		return output, err
 	}
+	output.UserMessage = userMsg
+
+	// Steps 7-10: Get the AI response and save it (durable steps)
+	assistantMsg, err := w.reply(ctx, input.Caller, prompt, userMsg, conv.ModelParams)
+	if err != nil {
+		return output, err
+	}
 	output.AssistantMessage = assistantMsg
 
+	// After the first exchange, name the conversation in a separate durable workflow;
+	// a cancelled workflow starts no more workflows
+	if len(messages) == 0 && conv.Title == "" && assistantMsg.Status != models.MessageCancelled {
+		titleInput := GenerateTitleInput{
+			ConversationID:   input.ConversationID,
+			Model:            conv.Model,
+			UserMessage:      input.Content,
+			AssistantMessage: assistantMsg.Content,
+			Caller:           input.Caller,
+		}
+		if _, err := dbos.RunWorkflow(ctx, w.GenerateTitleWorkflow, titleInput); err != nil {
+			return output, err
+		}
+	}
+
 	return output, nil
 }
 
-// getMessages retrieves all messages for a conversation
-func (w *ChatWorkflows) getMessages(ctx context.Context, conversationID uuid.UUID) ([]models.Message, error) {
-	rows, err := w.db.QueryContext(ctx,
-		"SELECT id, conversation_id, role, content, created_at FROM messages WHERE conversation_id = $1 ORDER BY created_at ASC",
-		conversationID)
+// promptContext is everything besides the user message that goes into a prompt
+type promptContext struct {
+	// systemPrompt is the persona and conversation prompt plus any document excerpts
+	systemPrompt string
+	history      []models.Message
+	summary      *models.ConversationSummary
+	sources      []models.DocumentMatch
+}
+
+// preparePrompt resolves the system prompt, the rolling summary of older messages on
+// the branch and relevant document excerpts for a user message that follows branch
+func (w *ChatWorkflows) preparePrompt(ctx dbos.DBOSContext, conv models.Conversation, branch []models.Message, content string) (promptContext, error) {
+	var p promptContext
+
+	// Resolve the system prompt from the persona and conversation (durable step)
+	systemPrompt, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (string, error) {
+		return w.systemPrompt(stepCtx, conv)
+	})
 	if err != nil {
-		return nil, err
+		return p, err
 	}
-	defer rows.Close()
 
-	var messages []models.Message
-	for rows.Next() {
-		var msg models.Message
-		if err := rows.Scan(&msg.ID, &msg.ConversationID, &msg.Role, &msg.Content, &msg.CreatedAt); err != nil {
-			return nil, err
+	// Load the rolling summary of older messages on this branch, if any (durable step)
+	summary, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (*models.ConversationSummary, error) {
+		return w.latestSummary(stepCtx, conv.ID, branch)
+	})
+	if err != nil {
+		return p, err
+	}
+	p.history, p.summary = unsummarized(branch, summary)
+
+	// Retrieve relevant excerpts from the owner's documents (durable step)
+	if w.embedder != nil && conv.OwnerID != nil {
+		p.sources, err = dbos.RunAsStep(ctx, func(stepCtx context.Context) ([]models.DocumentMatch, error) {
+			return w.retrieveSources(stepCtx, *conv.OwnerID, content), nil
+		})
+		if err != nil {
+			return p, err
 		}
-		messages = append(messages, msg)
 	}
-	return messages, nil
+	p.systemPrompt = withSources(systemPrompt, p.sources)
+	return p, nil
 }
 
-// saveMessage saves a message to the database
-func (w *ChatWorkflows) saveMessage(ctx context.Context, conversationID uuid.UUID, role, content string) (models.Message, error) {
-	id := uuid.New()
-	now := time.Now()
+// reply gets the AI response to a saved user message and saves it as the message's
+// child, charging the tokens used to caller
+func (w *ChatWorkflows) reply(ctx dbos.DBOSContext, caller Caller, p promptContext, userMsg models.Message, params models.ModelParams) (models.Message, error) {
+	summary, history := p.summary, p.history
+	chatReq := services.ChatRequest{
+		System:      withSummary(p.systemPrompt, summary),
+		Messages:    history,
+		UserMessage: userMsg.Content,
+		Params:      params,
+		Tools:       w.offeredTools(),
+	}
 
-	_, err := w.db.ExecContext(ctx,
-		"INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES ($1, $2, $3, $4, $5)",
-		id, conversationID, role, content, now)
+	// Fold history that no longer fits into the rolling summary (durable steps)
+	if w.contextBuilder.SummarizeEnabled() {
+		var err error
+		summary, history, err = w.summarizeOverflow(ctx, caller, userMsg.ConversationID, chatReq, summary)
+		if err != nil {
+			return models.Message{}, err
+		}
+		chatReq.System = withSummary(p.systemPrompt, summary)
+		chatReq.Messages = history
+	}
+
+	// Keep only the most recent history that fits the model's context window (durable step)
+	window, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (services.ContextWindow, error) {
+		return w.contextBuilder.Build(stepCtx, chatReq), nil
+	})
 	if err != nil {
 		return models.Message{}, err
 	}
+	chatReq.Messages = window.Messages
 
-	return models.Message{
-		ID:             id,
-		ConversationID: conversationID,
-		Role:           role,
-		Content:        content,
-		CreatedAt:      now,
-	}, nil
+	// Get AI response from the configured provider, running any tools it calls
+	// (durable steps - one per model turn and one per tool execution)
+	workflowID, err := dbos.GetWorkflowID(ctx)
+	if err != nil {
+		return models.Message{}, err
+	}
+	defer w.generations.begin(workflowID)()
+	aiResponse, toolMsgs, err := w.respond(ctx, workflowID, userMsg, chatReq)
+	if err != nil {
+		return models.Message{}, err
+	}
+
+	contextIDs := make([]uuid.UUID, 0, len(window.Messages)+len(toolMsgs)+1)
+	for _, msg := range window.Messages {
+		contextIDs = append(contextIDs, msg.ID)
+	}
+	contextIDs = append(contextIDs, userMsg.ID)
+	for _, msg := range toolMsgs {
+		contextIDs = append(contextIDs, msg.ID)
+	}
+	replyParent := contextIDs[len(contextIDs)-1]
+	var summaryID *uuid.UUID
+	if summary != nil {
+		summaryID = &summary.ID
+	}
+	assistantMsg := withTurn(models.Message{
+		ConversationID:    userMsg.ConversationID,
+		Role:              "assistant",
+		Content:           aiResponse.Content,
+		ContextMessageIDs: contextIDs,
+		ContextSummaryID:  summaryID,
+		Citations:         citations(p.sources),
+		ParentID:          &replyParent,
+	}, aiResponse.ChatResult)
+	usage := turnUsage(userMsg.ConversationID, aiResponse, toolMsgs)
+	if aiResponse.Cancelled {
+		return w.saveCancelled(ctx, caller, usage, assistantMsg)
+	}
+
+	// Record the tokens used and charge them to the caller's daily quota (durable step)
+	if _, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (bool, error) {
+		return true, w.quotas.RecordTokens(stepCtx, caller, usage...)
+	}); err != nil {
+		return models.Message{}, err
+	}
+
+	// Save assistant message to database (durable step)
+	return dbos.RunAsStep(ctx, func(stepCtx context.Context) (models.Message, error) {
+		return w.saveMessage(stepCtx, assistantMsg)
+	})
+}
+
+// withTurn records on msg the model, finish reason and token usage reported for
+// the model turn that produced it
+func withTurn(msg models.Message, turn services.ChatResult) models.Message {
+	if turn.Model != "" {
+		msg.Model = &turn.Model
+	}
+	if turn.FinishReason != "" {
+		msg.FinishReason = &turn.FinishReason
+	}
+	msg.Usage = turn.Usage
+	return msg
+}
+
+// complete asks the provider for a reply, streaming it if the provider supports that
+// so the text produced so far survives a cancellation. If a listener is subscribed
+// to this workflow, deltas are relayed to it as they arrive.
+func (w *ChatWorkflows) complete(ctx context.Context, workflowID string, req services.ChatRequest) (completion, error) {
+	ctx, done := w.generations.turnContext(ctx, workflowID)
+	defer done()
+
+	// A retried turn starts over, so the listener drops what an earlier attempt sent
+	sub := w.streams.listener(workflowID)
+	if sub != nil {
+		sub.reset()
+	}
+	streamer, ok := w.provider.(services.StreamingProvider)
+	if !ok {
+		reply, err := w.provider.Chat(ctx, req)
+		if err != nil {
+			return w.cancelled(workflowID, "", err)
+		}
+		if sub != nil {
+			sub.send(reply.Content)
+		}
+		return completion{ChatResult: reply}, nil
+	}
+
+	var partial strings.Builder
+	reply, err := streamer.ChatStream(ctx, req, func(delta string) {
+		partial.WriteString(delta)
+		if sub != nil {
+			sub.send(delta)
+		}
+	})
+	if err != nil {
+		return w.cancelled(workflowID, partial.String(), err)
+	}
+	return completion{ChatResult: reply}, nil
+}
+
+// cancelled turns the error of a model turn stopped by CancelReply into a cancelled
+// completion holding the partial text. Other errors are returned unchanged.
+func (w *ChatWorkflows) cancelled(workflowID, partial string, err error) (completion, error) {
+	if w.generations.wasCancelled(workflowID) {
+		return completion{ChatResult: services.ChatResult{Content: partial}, Cancelled: true}, nil
+	}
+	return completion{}, err
+}
+
+// getConversation retrieves a conversation and its settings
+func (w *ChatWorkflows) getConversation(ctx context.Context, conversationID uuid.UUID) (models.Conversation, error) {
+	return models.ScanConversation(w.db.QueryRowContext(ctx,
+		"SELECT "+models.ConversationColumns+" FROM conversations WHERE id = $1", conversationID))
+}
+
+// systemPrompt builds the system message for a conversation: the persona's
+// prompt (if any, and only if the conversation's owner owns it) followed by the
+// conversation's own system prompt (if any)
+func (w *ChatWorkflows) systemPrompt(ctx context.Context, conv models.Conversation) (string, error) {
+	var parts []string
+	if conv.PersonaID != nil {
+		var personaPrompt string
+		err := w.db.QueryRowContext(ctx,
+			"SELECT system_prompt FROM personas WHERE id = $1 AND owner_id = $2",
+			*conv.PersonaID, conv.OwnerID).Scan(&personaPrompt)
+		if err != nil && err != sql.ErrNoRows {
+			return "", err
+		}
+		if personaPrompt != "" {
+			parts = append(parts, personaPrompt)
+		}
+	}
+	if conv.SystemPrompt != nil && *conv.SystemPrompt != "" {
+		parts = append(parts, *conv.SystemPrompt)
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
+
+// saveMessage saves a message to the database, assigning its ID and timestamp,
+// and makes it the tip of the conversation's active branch
+func (w *ChatWorkflows) saveMessage(ctx context.Context, msg models.Message) (models.Message, error) {
+	msg.ID = uuid.New()
+	msg.CreatedAt = time.Now()
+	if msg.Status == "" {
+		msg.Status = models.MessageComplete
+	}
+
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Message{}, err
+	}
+	defer tx.Rollback()
+
+	if err := insertMessage(ctx, tx, msg); err != nil {
+		return models.Message{}, err
+	}
+	return msg, tx.Commit()
+}
+
+// insertMessage inserts a message that already has its ID and timestamp and makes
+// it the tip of the conversation's active branch
+func insertMessage(ctx context.Context, tx *sql.Tx, msg models.Message) error {
+	var promptTokens, completionTokens, totalTokens *int
+	if u := msg.Usage; u != nil {
+		promptTokens, completionTokens, totalTokens = &u.PromptTokens, &u.CompletionTokens, &u.TotalTokens
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO messages (id, conversation_id, role, content, created_at, context_message_ids, context_summary_id,
+		                       citations, tool_calls, tool_call_id, parent_id, status,
+		                       model, finish_reason, prompt_tokens, completion_tokens, total_tokens)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`,
+		msg.ID, msg.ConversationID, msg.Role, msg.Content, msg.CreatedAt, pq.Array(msg.ContextMessageIDs), msg.ContextSummaryID,
+		msg.Citations, msg.ToolCalls, msg.ToolCallID, msg.ParentID, msg.Status,
+		msg.Model, msg.FinishReason, promptTokens, completionTokens, totalTokens)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE conversations SET active_leaf_id = $1 WHERE id = $2", msg.ID, msg.ConversationID)
+	return err
+}
+
+// CreateConversationInput contains the input for the CreateConversation workflow
+type CreateConversationInput struct {
+	OwnerID      uuid.UUID
+	Title        string
+	SystemPrompt *string
+	PersonaID    *uuid.UUID
+	Params       models.ModelParams
 }
 
 // CreateConversationWorkflow creates a new conversation durably
-func (w *ChatWorkflows) CreateConversationWorkflow(ctx dbos.DBOSContext, _ string) (models.Conversation, error) {
+func (w *ChatWorkflows) CreateConversationWorkflow(ctx dbos.DBOSContext, input CreateConversationInput) (models.Conversation, error) {
 	return dbos.RunAsStep(ctx, func(stepCtx context.Context) (models.Conversation, error) {
-		id := uuid.New()
-		now := time.Now()
+		return insertConversation(stepCtx, w.db, input)
+	})
+}
 
-		_, err := w.db.ExecContext(stepCtx,
-			"INSERT INTO conversations (id, created_at) VALUES ($1, $2)",
-			id, now)
-		if err != nil {
-			return models.Conversation{}, err
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// insertConversation inserts a new conversation, assigning its ID and timestamp
+func insertConversation(ctx context.Context, db execer, input CreateConversationInput) (models.Conversation, error) {
+	id := uuid.New()
+	now := time.Now()
+	p := input.Params
+
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO conversations (id, owner_id, title, created_at, system_prompt, persona_id, model, temperature, top_p, max_tokens, stop_sequences)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		id, input.OwnerID, input.Title, now, input.SystemPrompt, input.PersonaID,
+		p.Model, p.Temperature, p.TopP, p.MaxTokens, pq.Array(p.StopSequences))
+	if err != nil {
+		return models.Conversation{}, err
+	}
+
+	return models.Conversation{
+		ID:           id,
+		OwnerID:      &input.OwnerID,
+		Title:        input.Title,
+		CreatedAt:    now,
+		SystemPrompt: input.SystemPrompt,
+		PersonaID:    input.PersonaID,
+		ModelParams:  p,
+	}, nil
+}
+
+// UpdateConversationInput contains the input for the UpdateConversation workflow
+type UpdateConversationInput struct {
+	ConversationID uuid.UUID
+	Update         models.UpdateConversationRequest
+}
+
+// UpdateConversationWorkflow applies a partial update to a conversation durably
+func (w *ChatWorkflows) UpdateConversationWorkflow(ctx dbos.DBOSContext, input UpdateConversationInput) (models.Conversation, error) {
+	return dbos.RunAsStep(ctx, func(stepCtx context.Context) (models.Conversation, error) {
+		u := input.Update
+		var sets []string
+		var args []any
+		set := func(column string, value any) {
+			args = append(args, value)
+			sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+		}
+		if u.Title != nil {
+			set("title", strings.TrimSpace(*u.Title))
+		}
+		if u.SystemPrompt != nil {
+			if *u.SystemPrompt == "" {
+				set("system_prompt", nil)
+			} else {
+				set("system_prompt", *u.SystemPrompt)
+			}
+		}
+		if u.PersonaID != nil {
+			if *u.PersonaID == "" {
+				set("persona_id", nil)
+			} else {
+				set("persona_id", *u.PersonaID)
+			}
+		}
+		if u.Model != nil {
+			// An empty model name resets the conversation to the provider default
+			if *u.Model == "" {
+				set("model", nil)
+			} else {
+				set("model", *u.Model)
+			}
+		}
+		if u.Temperature != nil {
+			set("temperature", *u.Temperature)
+		}
+		if u.TopP != nil {
+			set("top_p", *u.TopP)
+		}
+		if u.MaxTokens != nil {
+			set("max_tokens", *u.MaxTokens)
+		}
+		if u.StopSequences != nil {
+			set("stop_sequences", pq.Array(*u.StopSequences))
 		}
 
-		return models.Conversation{
-			ID:        id,
-			CreatedAt: now,
-		}, nil
+		if len(sets) == 0 {
+			return w.getConversation(stepCtx, input.ConversationID)
+		}
+		args = append(args, input.ConversationID)
+		return models.ScanConversation(w.db.QueryRowContext(stepCtx,
+			fmt.Sprintf("UPDATE conversations SET %s WHERE id = $%d RETURNING %s",
+				strings.Join(sets, ", "), len(args), models.ConversationColumns),
+			args...))
 	})
 }
 
 // DeleteConversationWorkflow deletes a conversation and its messages durably
 func (w *ChatWorkflows) DeleteConversationWorkflow(ctx dbos.DBOSContext, conversationID uuid.UUID) (bool, error) {
-	// Step 1: Delete messages
+	// Step 1: Delete summaries
 	_, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (bool, error) {
+		_, err := w.db.ExecContext(stepCtx, "DELETE FROM conversation_summaries WHERE conversation_id = $1", conversationID)
+		return err == nil, err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// Step 2: Delete messages
+	_, err = dbos.RunAsStep(ctx, func(stepCtx context.Context) (bool, error) {
 		_, err := w.db.ExecContext(stepCtx, "DELETE FROM messages WHERE conversation_id = $1", conversationID)
 		return err == nil, err
 	})
@@ -153,7 +534,7 @@ func (w *ChatWorkflows) DeleteConversationWorkflow(ctx dbos.DBOSContext, convers
 		return false, err
 	}
 
-	// Step 2: Delete conversation
+	// Step 3: Delete conversation
 	return dbos.RunAsStep(ctx, func(stepCtx context.Context) (bool, error) {
 		_, err := w.db.ExecContext(stepCtx, "DELETE FROM conversations WHERE id = $1", conversationID)
 		return err == nil, err
@@ -0,0 +1,129 @@
+package workflows
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"chat-app/models"
+	"chat-app/services"
+
+	"github.com/dbos-inc/dbos-transact-golang/dbos"
+	"github.com/google/uuid"
+)
+
+// maxLoggedTitleLength bounds the title of a conversation logged from a completion
+const maxLoggedTitleLength = 60
+
+// CompletionInput contains the input for the Completion workflow
+type CompletionInput struct {
+	// Caller is charged for the tokens the completion uses
+	Caller  Caller
+	Request services.ChatRequest
+	// Log saves the exchange as a new conversation owned by the caller
+	Log bool
+}
+
+// CompletionOutput contains the output of the Completion workflow
+type CompletionOutput struct {
+	services.ChatResult
+	// ConversationID is the conversation the exchange was logged to, if it was
+	ConversationID *uuid.UUID
+}
+
+// CompletionWorkflow answers a chat completion request made through the
+// OpenAI-compatible API. The request carries its own history, so nothing is read
+// from the database; the exchange is only saved if input.Log is set.
+func (w *ChatWorkflows) CompletionWorkflow(ctx dbos.DBOSContext, input CompletionInput) (CompletionOutput, error) {
+	var output CompletionOutput
+
+	workflowID, err := dbos.GetWorkflowID(ctx)
+	if err != nil {
+		return output, err
+	}
+
+	// Step 1: Refuse callers over their daily token limit (durable step)
+	if err := w.checkQuota(ctx, input.Caller); err != nil {
+		return output, err
+	}
+
+	// Step 2: Ask the model (durable step, retried per the retry policy)
+	reply, err := runModelStep(ctx, w.retry, func(stepCtx context.Context) (completion, error) {
+		return w.complete(stepCtx, workflowID, input.Request)
+	})
+	if err != nil {
+		return output, err
+	}
+	output.ChatResult = reply.ChatResult
+
+	// Step 3: Record the tokens used and charge them to the caller's daily quota,
+	// whether or not the exchange is logged (durable step)
+	if _, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (bool, error) {
+		return true, w.quotas.RecordTokens(stepCtx, input.Caller, callUsage(nil, reply.ChatResult))
+	}); err != nil {
+		return output, err
+	}
+
+	// Step 4: Log the exchange as a conversation (durable step)
+	if input.Log {
+		conv, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (models.Conversation, error) {
+			return w.logCompletion(stepCtx, input, reply.ChatResult)
+		})
+		if err != nil {
+			return output, err
+		}
+		output.ConversationID = &conv.ID
+	}
+
+	return output, nil
+}
+
+// logCompletion saves a completion request and its reply as a new conversation,
+// all in one transaction
+func (w *ChatWorkflows) logCompletion(ctx context.Context, input CompletionInput, reply services.ChatResult) (models.Conversation, error) {
+	req := input.Request
+	title := []rune(strings.Join(strings.Fields(req.UserMessage), " "))
+	if len(title) > maxLoggedTitleLength {
+		title = append(title[:maxLoggedTitleLength-1], '…')
+	}
+	var systemPrompt *string
+	if req.System != "" {
+		systemPrompt = &req.System
+	}
+
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+	defer tx.Rollback()
+
+	conv, err := insertConversation(ctx, tx, CreateConversationInput{
+		OwnerID:      input.Caller.UserID,
+		Title:        string(title),
+		SystemPrompt: systemPrompt,
+		Params:       req.Params,
+	})
+	if err != nil {
+		return models.Conversation{}, err
+	}
+
+	transcript := append([]models.Message{}, req.Messages...)
+	transcript = append(transcript,
+		models.Message{Role: models.RoleUser, Content: req.UserMessage},
+		withTurn(models.Message{Role: models.RoleAssistant, Content: reply.Content}, reply))
+	var parentID *uuid.UUID
+	for _, msg := range transcript {
+		msg.ID = uuid.New()
+		msg.ConversationID = conv.ID
+		msg.CreatedAt = time.Now()
+		msg.ParentID = parentID
+		msg.Status = models.MessageComplete
+		if err := insertMessage(ctx, tx, msg); err != nil {
+			return models.Conversation{}, err
+		}
+		parentID = &msg.ID
+	}
+	conv.ActiveLeafID = parentID
+
+	return conv, tx.Commit()
+}
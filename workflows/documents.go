@@ -0,0 +1,287 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"chat-app/models"
+	"chat-app/services"
+
+	"github.com/dbos-inc/dbos-transact-golang/dbos"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	// ingestBatchSize is how many chunks are embedded and stored per durable step
+	ingestBatchSize = 32
+	// citationSnippetLength is how many characters of a chunk are kept on a citation
+	citationSnippetLength = 300
+)
+
+// DocumentsEnabled reports whether an embedding model is configured for document ingestion and retrieval
+func (w *ChatWorkflows) DocumentsEnabled() bool {
+	return w.embedder != nil
+}
+
+// NewDocument is an uploaded document to be ingested
+type NewDocument struct {
+	OwnerID  uuid.UUID
+	Title    string
+	Filename string
+	Content  string
+}
+
+// CreateDocument stores an uploaded document and its text with status "processing",
+// ready to be passed to IngestDocumentWorkflow by ID
+func (w *ChatWorkflows) CreateDocument(ctx context.Context, input NewDocument) (models.Document, error) {
+	return models.ScanDocument(w.db.QueryRowContext(ctx,
+		`INSERT INTO documents (id, owner_id, title, filename, status, content, created_at)
+		 VALUES ($1, $2, $3, $4, 'processing', $5, $6)
+		 RETURNING `+models.DocumentColumns,
+		uuid.New(), input.OwnerID, input.Title, input.Filename, input.Content, time.Now()))
+}
+
+// IngestDocumentWorkflow chunks a document stored by CreateDocument, embeds the
+// chunks and marks it ready for retrieval. Only the document ID is passed in, so
+// the text is never copied into the workflow's recorded input or step outputs.
+// The document is visible with status "processing" until every chunk is embedded.
+func (w *ChatWorkflows) IngestDocumentWorkflow(ctx dbos.DBOSContext, documentID uuid.UUID) (models.Document, error) {
+	// Step 1: Split the stored text into chunks (durable step)
+	count, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (int, error) {
+		return w.splitDocument(stepCtx, documentID)
+	})
+	if err != nil {
+		return w.failDocument(ctx, documentID, err)
+	}
+
+	// Step 2: Embed the chunks, one durable step per batch
+	for start := 0; start < count; start += ingestBatchSize {
+		end := min(start+ingestBatchSize, count)
+		_, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (int, error) {
+			return w.embedChunks(stepCtx, documentID, start, end)
+		})
+		if err != nil {
+			return w.failDocument(ctx, documentID, err)
+		}
+	}
+
+	// Step 3: Mark the document ready (durable step)
+	return dbos.RunAsStep(ctx, func(stepCtx context.Context) (models.Document, error) {
+		return models.ScanDocument(w.db.QueryRowContext(stepCtx,
+			"UPDATE documents SET status = 'ready' WHERE id = $1 RETURNING "+models.DocumentColumns,
+			documentID))
+	})
+}
+
+// splitDocument stores a document's chunks without embeddings and drops the text
+// from the document row, all in one transaction. It returns the number of chunks.
+func (w *ChatWorkflows) splitDocument(ctx context.Context, documentID uuid.UUID) (int, error) {
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var content *string
+	var count int
+	err = tx.QueryRowContext(ctx,
+		"SELECT content, chunk_count FROM documents WHERE id = $1 FOR UPDATE", documentID).Scan(&content, &count)
+	if err != nil {
+		return 0, err
+	}
+	// A retried step may find the document already split
+	if content == nil {
+		return count, nil
+	}
+
+	chunks := services.ChunkText(*content, services.DefaultChunkSize, services.DefaultChunkOverlap)
+	for i, chunk := range chunks {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO document_chunks (id, document_id, chunk_index, content) VALUES ($1, $2, $3, $4)",
+			uuid.New(), documentID, i, chunk)
+		if err != nil {
+			return 0, err
+		}
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE documents SET content = NULL, chunk_count = $1 WHERE id = $2", len(chunks), documentID)
+	if err != nil {
+		return 0, err
+	}
+	return len(chunks), tx.Commit()
+}
+
+// embedChunks embeds the chunks of a document with index in [first, end) and stores
+// their embeddings. A retried step simply embeds them again.
+func (w *ChatWorkflows) embedChunks(ctx context.Context, documentID uuid.UUID, first, end int) (int, error) {
+	if w.embedder == nil {
+		return 0, fmt.Errorf("no embedding model configured")
+	}
+
+	rows, err := w.db.QueryContext(ctx,
+		`SELECT id, content FROM document_chunks
+		 WHERE document_id = $1 AND chunk_index >= $2 AND chunk_index < $3
+		 ORDER BY chunk_index`,
+		documentID, first, end)
+	if err != nil {
+		return 0, err
+	}
+	var ids []uuid.UUID
+	var texts []string
+	for rows.Next() {
+		var id uuid.UUID
+		var text string
+		if err := rows.Scan(&id, &text); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+		texts = append(texts, text)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	vectors, err := w.embedder.Embed(ctx, texts)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	for i, id := range ids {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE document_chunks SET embedding = $1 WHERE id = $2", pq.Array(vectors[i]), id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), tx.Commit()
+}
+
+// failDocument records why ingestion failed and returns the original error
+func (w *ChatWorkflows) failDocument(ctx dbos.DBOSContext, documentID uuid.UUID, cause error) (models.Document, error) {
+	failed, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (models.Document, error) {
+		return models.ScanDocument(w.db.QueryRowContext(stepCtx,
+			"UPDATE documents SET status = 'failed', error = $1 WHERE id = $2 RETURNING "+models.DocumentColumns,
+			cause.Error(), documentID))
+	})
+	if err != nil {
+		return failed, err
+	}
+	return failed, cause
+}
+
+// DeleteDocumentWorkflow deletes a document and its chunks durably
+func (w *ChatWorkflows) DeleteDocumentWorkflow(ctx dbos.DBOSContext, documentID uuid.UUID) (bool, error) {
+	return dbos.RunAsStep(ctx, func(stepCtx context.Context) (bool, error) {
+		// Chunks are removed by ON DELETE CASCADE
+		_, err := w.db.ExecContext(stepCtx, "DELETE FROM documents WHERE id = $1", documentID)
+		return err == nil, err
+	})
+}
+
+// SearchDocuments returns the chunks of the owner's ready documents most similar to query,
+// best first, skipping chunks scoring below minScore
+func (w *ChatWorkflows) SearchDocuments(ctx context.Context, ownerID uuid.UUID, query string, limit int, minScore float64) ([]models.DocumentMatch, error) {
+	if w.embedder == nil {
+		return nil, fmt.Errorf("no embedding model configured")
+	}
+
+	// Skip the embedding request entirely for users without documents
+	var hasDocuments bool
+	err := w.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM documents WHERE owner_id = $1 AND status = 'ready')",
+		ownerID).Scan(&hasDocuments)
+	if err != nil || !hasDocuments {
+		return nil, err
+	}
+
+	vectors, err := w.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := w.db.QueryContext(ctx,
+		`SELECT c.document_id, d.title, c.id, c.chunk_index, c.content, s.score
+		 FROM document_chunks c
+		 JOIN documents d ON d.id = c.document_id
+		 CROSS JOIN LATERAL (
+		     SELECT sum(a * b) AS score FROM unnest(c.embedding, $2::real[]) AS t(a, b)
+		 ) s
+		 WHERE d.owner_id = $1 AND d.status = 'ready' AND s.score >= $3
+		 ORDER BY s.score DESC
+		 LIMIT $4`,
+		ownerID, pq.Array(vectors[0]), minScore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matches []models.DocumentMatch
+	for rows.Next() {
+		var m models.DocumentMatch
+		if err := rows.Scan(&m.DocumentID, &m.DocumentTitle, &m.ChunkID, &m.ChunkIndex, &m.Content, &m.Score); err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// retrieveSources finds document excerpts relevant to a user message. Retrieval is
+// best effort: if the embedding server is unavailable the reply is generated without sources.
+func (w *ChatWorkflows) retrieveSources(ctx context.Context, ownerID uuid.UUID, content string) []models.DocumentMatch {
+	matches, err := w.SearchDocuments(ctx, ownerID, content, w.embedder.TopK(), w.embedder.MinScore())
+	if err != nil {
+		log.Printf("Document retrieval failed, continuing without sources: %v", err)
+		return nil
+	}
+	return matches
+}
+
+// withSources appends retrieved document excerpts to the system prompt, numbered for citation
+func withSources(systemPrompt string, sources []models.DocumentMatch) string {
+	if len(sources) == 0 {
+		return systemPrompt
+	}
+	var b strings.Builder
+	b.WriteString("Excerpts from the user's documents that may be relevant are listed below. ")
+	b.WriteString("When you use one, cite it by its number in square brackets, e.g. [1]. ")
+	b.WriteString("If they do not answer the question, say so rather than guessing.")
+	for i, src := range sources {
+		fmt.Fprintf(&b, "\n\n[%d] %s (part %d)\n%s", i+1, src.DocumentTitle, src.ChunkIndex+1, src.Content)
+	}
+	if systemPrompt == "" {
+		return b.String()
+	}
+	return systemPrompt + "\n\n" + b.String()
+}
+
+// citations describes the sources injected into a prompt, numbered as in withSources
+func citations(sources []models.DocumentMatch) models.Citations {
+	var out models.Citations
+	for i, src := range sources {
+		snippet := src.Content
+		if r := []rune(snippet); len(r) > citationSnippetLength {
+			snippet = string(r[:citationSnippetLength]) + "…"
+		}
+		out = append(out, models.Citation{
+			Index:         i + 1,
+			DocumentID:    src.DocumentID,
+			DocumentTitle: src.DocumentTitle,
+			ChunkID:       src.ChunkID,
+			ChunkIndex:    src.ChunkIndex,
+			Snippet:       snippet,
+			Score:         src.Score,
+		})
+	}
+	return out
+}
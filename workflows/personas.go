@@ -0,0 +1,82 @@
+package workflows
+
+import (
+	"context"
+	"time"
+
+	"chat-app/models"
+
+	"github.com/dbos-inc/dbos-transact-golang/dbos"
+	"github.com/google/uuid"
+)
+
+// CreatePersonaInput contains the input for the CreatePersona workflow
+type CreatePersonaInput struct {
+	OwnerID uuid.UUID
+	Persona models.PersonaRequest
+}
+
+// UpdatePersonaInput contains the input for the UpdatePersona workflow
+type UpdatePersonaInput struct {
+	OwnerID   uuid.UUID
+	PersonaID uuid.UUID
+	Persona   models.PersonaRequest
+}
+
+// DeletePersonaInput contains the input for the DeletePersona workflow
+type DeletePersonaInput struct {
+	OwnerID   uuid.UUID
+	PersonaID uuid.UUID
+}
+
+// CreatePersonaWorkflow creates a new persona durably
+func (w *ChatWorkflows) CreatePersonaWorkflow(ctx dbos.DBOSContext, input CreatePersonaInput) (models.Persona, error) {
+	return dbos.RunAsStep(ctx, func(stepCtx context.Context) (models.Persona, error) {
+		id := uuid.New()
+		now := time.Now()
+		p := input.Persona
+
+		_, err := w.db.ExecContext(stepCtx,
+			"INSERT INTO personas (id, owner_id, name, description, system_prompt, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $6)",
+			id, input.OwnerID, p.Name, p.Description, p.SystemPrompt, now)
+		if err != nil {
+			return models.Persona{}, err
+		}
+
+		return models.Persona{
+			ID:           id,
+			OwnerID:      &input.OwnerID,
+			Name:         p.Name,
+			Description:  p.Description,
+			SystemPrompt: p.SystemPrompt,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}, nil
+	})
+}
+
+// UpdatePersonaWorkflow replaces the fields of one of the owner's personas durably
+func (w *ChatWorkflows) UpdatePersonaWorkflow(ctx dbos.DBOSContext, input UpdatePersonaInput) (models.Persona, error) {
+	return dbos.RunAsStep(ctx, func(stepCtx context.Context) (models.Persona, error) {
+		return models.ScanPersona(w.db.QueryRowContext(stepCtx,
+			`UPDATE personas SET name = $1, description = $2, system_prompt = $3, updated_at = $4
+			 WHERE id = $5 AND owner_id = $6 RETURNING `+models.PersonaColumns,
+			input.Persona.Name, input.Persona.Description, input.Persona.SystemPrompt, time.Now(),
+			input.PersonaID, input.OwnerID))
+	})
+}
+
+// DeletePersonaWorkflow deletes one of the owner's personas durably and reports
+// whether it existed. Conversations using it keep their own system prompt;
+// persona_id is cleared by the foreign key.
+func (w *ChatWorkflows) DeletePersonaWorkflow(ctx dbos.DBOSContext, input DeletePersonaInput) (bool, error) {
+	return dbos.RunAsStep(ctx, func(stepCtx context.Context) (bool, error) {
+		res, err := w.db.ExecContext(stepCtx,
+			"DELETE FROM personas WHERE id = $1 AND owner_id = $2", input.PersonaID, input.OwnerID)
+		if err != nil {
+			return false, err
+		}
+		n, err := res.RowsAffected()
+		return n > 0, err
+	})
+}
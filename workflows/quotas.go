@@ -0,0 +1,317 @@
+package workflows
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"chat-app/models"
+	"chat-app/services"
+
+	"github.com/dbos-inc/dbos-transact-golang/dbos"
+	"github.com/google/uuid"
+)
+
+// RateLimits caps how much a user, and each of their API keys separately, may ask
+// the model for. Zero means unlimited.
+type RateLimits struct {
+	UserRequestsPerMinute int
+	UserTokensPerDay      int
+	KeyRequestsPerMinute  int
+	KeyTokensPerDay       int
+}
+
+// LoadRateLimits reads RATE_LIMIT_USER_RPM, RATE_LIMIT_USER_TOKENS_PER_DAY,
+// RATE_LIMIT_KEY_RPM and RATE_LIMIT_KEY_TOKENS_PER_DAY. Unset means unlimited.
+func LoadRateLimits() (RateLimits, error) {
+	var limits RateLimits
+	for envVar, dst := range map[string]*int{
+		"RATE_LIMIT_USER_RPM":            &limits.UserRequestsPerMinute,
+		"RATE_LIMIT_USER_TOKENS_PER_DAY": &limits.UserTokensPerDay,
+		"RATE_LIMIT_KEY_RPM":             &limits.KeyRequestsPerMinute,
+		"RATE_LIMIT_KEY_TOKENS_PER_DAY":  &limits.KeyTokensPerDay,
+	} {
+		v := os.Getenv(envVar)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return limits, fmt.Errorf("invalid %s %q", envVar, v)
+		}
+		*dst = n
+	}
+	return limits, nil
+}
+
+// Caller is who a reply is generated for; quotas are charged to them
+type Caller struct {
+	UserID uuid.UUID
+	// APIKeyID is the key the request was made with, or nil for a login session
+	APIKeyID *uuid.UUID
+}
+
+// QuotaError reports an exceeded rate limit
+type QuotaError struct {
+	// Limit names the limit, e.g. "requests per minute for this API key"
+	Limit string
+	// RetryAfter is how long until the limit allows another request
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("rate limit exceeded: %s; retry after %ds", e.Limit, e.RetryAfterSeconds())
+}
+
+// RetryAfterSeconds rounds RetryAfter up to whole seconds, at least one
+func (e *QuotaError) RetryAfterSeconds() int {
+	return max(int(math.Ceil(e.RetryAfter.Seconds())), 1)
+}
+
+// Quotas enforces RateLimits against the usage ledger, which holds one row per
+// admitted request and one per model call
+type Quotas struct {
+	db     *sql.DB
+	limits RateLimits
+}
+
+// NewQuotas creates a quota enforcer
+func NewQuotas(db *sql.DB, limits RateLimits) *Quotas {
+	return &Quotas{db: db, limits: limits}
+}
+
+// Enabled reports whether any limit is configured
+func (q *Quotas) Enabled() bool {
+	return q.limits != RateLimits{}
+}
+
+// quota is one limit that applies to a caller
+type quota struct {
+	name   string
+	column string // usage_ledger column identifying the caller
+	id     uuid.UUID
+	limit  int
+}
+
+// quotas lists the caller's request-per-minute or token-per-day limits
+func (q *Quotas) quotas(caller Caller, tokens bool) []quota {
+	userLimit, keyLimit, unit := q.limits.UserRequestsPerMinute, q.limits.KeyRequestsPerMinute, "requests per minute"
+	if tokens {
+		userLimit, keyLimit, unit = q.limits.UserTokensPerDay, q.limits.KeyTokensPerDay, "tokens per day"
+	}
+	var list []quota
+	if userLimit > 0 {
+		list = append(list, quota{unit + " for this user", "user_id", caller.UserID, userLimit})
+	}
+	if keyLimit > 0 && caller.APIKeyID != nil {
+		list = append(list, quota{unit + " for this API key", "api_key_id", *caller.APIKeyID, keyLimit})
+	}
+	return list
+}
+
+// AdmitRequest records a request for the caller, or returns a QuotaError without
+// recording it if the caller is over a request or daily token limit
+func (q *Quotas) AdmitRequest(ctx context.Context, caller Caller) error {
+	if !q.Enabled() {
+		return nil
+	}
+
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialize admissions per user so concurrent requests cannot all slip under the limit
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", caller.UserID.String()); err != nil {
+		return err
+	}
+
+	for _, quota := range q.quotas(caller, false) {
+		// A slot frees up once the oldest request in the window is a minute old
+		var count int
+		var untilFree sql.NullFloat64
+		err := tx.QueryRowContext(ctx, fmt.Sprintf(
+			`SELECT COUNT(*), EXTRACT(EPOCH FROM MIN(created_at) + interval '1 minute' - localtimestamp)
+			 FROM usage_ledger
+			 WHERE %s = $1 AND requests > 0 AND created_at > localtimestamp - interval '1 minute'`, quota.column),
+			quota.id).Scan(&count, &untilFree)
+		if err != nil {
+			return err
+		}
+		if count >= quota.limit {
+			return &QuotaError{
+				Limit:      fmt.Sprintf("%d %s", quota.limit, quota.name),
+				RetryAfter: time.Duration(untilFree.Float64 * float64(time.Second)),
+			}
+		}
+	}
+	if err := q.checkTokens(ctx, tx, caller); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO usage_ledger (user_id, api_key_id, requests) VALUES ($1, $2, 1)",
+		caller.UserID, caller.APIKeyID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CheckTokens returns a QuotaError if the caller has used up a daily token limit
+func (q *Quotas) CheckTokens(ctx context.Context, caller Caller) error {
+	return q.checkTokens(ctx, q.db, caller)
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (q *Quotas) checkTokens(ctx context.Context, db queryer, caller Caller) error {
+	for _, quota := range q.quotas(caller, true) {
+		var used int64
+		var untilReset float64
+		err := db.QueryRowContext(ctx, fmt.Sprintf(
+			`SELECT COALESCE(SUM(tokens), 0),
+			        EXTRACT(EPOCH FROM date_trunc('day', localtimestamp) + interval '1 day' - localtimestamp)
+			 FROM usage_ledger
+			 WHERE %s = $1 AND created_at >= date_trunc('day', localtimestamp)`, quota.column),
+			quota.id).Scan(&used, &untilReset)
+		if err != nil {
+			return err
+		}
+		if used >= int64(quota.limit) {
+			return &QuotaError{
+				Limit:      fmt.Sprintf("%d %s", quota.limit, quota.name),
+				RetryAfter: time.Duration(untilReset * float64(time.Second)),
+			}
+		}
+	}
+	return nil
+}
+
+// ModelUsage is the token usage of one model call, as kept in the usage ledger
+type ModelUsage struct {
+	// ConversationID is the conversation the call was made for, if any. The ledger
+	// keeps it after the conversation is deleted.
+	ConversationID *uuid.UUID
+	Model          string
+	// Usage is nil if the backend did not report token counts
+	Usage *models.Usage
+}
+
+// callUsage is the usage of a model call made for a conversation
+func callUsage(conversationID *uuid.UUID, result services.ChatResult) ModelUsage {
+	return ModelUsage{ConversationID: conversationID, Model: result.Model, Usage: result.Usage}
+}
+
+// RecordTokens records the usage of model calls made for the caller in the usage
+// ledger, charging their tokens to the caller's daily limits. Calls whose usage the
+// backend did not report are skipped.
+func (q *Quotas) RecordTokens(ctx context.Context, caller Caller, calls ...ModelUsage) error {
+	if caller.UserID == uuid.Nil {
+		return nil
+	}
+	for _, call := range calls {
+		if call.Usage == nil {
+			continue
+		}
+		var model *string
+		if call.Model != "" {
+			model = &call.Model
+		}
+		_, err := q.db.ExecContext(ctx,
+			`INSERT INTO usage_ledger (user_id, api_key_id, conversation_id, model, prompt_tokens, completion_tokens, tokens)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			caller.UserID, caller.APIKeyID, call.ConversationID, model,
+			call.Usage.PromptTokens, call.Usage.CompletionTokens, call.Usage.TotalTokens)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Quotas returns the rate limits applied to callers
+func (w *ChatWorkflows) Quotas() *Quotas {
+	return w.quotas
+}
+
+// quotaStepName names the durable step in which a reply workflow checks the daily
+// token limit, so QuotaFailure can find it
+const quotaStepName = "checkQuota"
+
+// checkQuota fails a reply for a caller who has used up a daily token limit. The
+// check runs as a durable step whose output, rather than its error, holds the
+// QuotaError: DBOS keeps errors only as messages, outputs keep their structure.
+// Workflows started without a caller are not limited.
+func (w *ChatWorkflows) checkQuota(ctx dbos.DBOSContext, caller Caller) error {
+	quotaErr, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (*QuotaError, error) {
+		if caller.UserID == uuid.Nil {
+			return nil, nil
+		}
+		err := w.quotas.CheckTokens(stepCtx, caller)
+		var quotaErr *QuotaError
+		if errors.As(err, &quotaErr) {
+			return quotaErr, nil
+		}
+		return nil, err
+	}, dbos.WithStepName(quotaStepName))
+	if err != nil {
+		return err
+	}
+	if quotaErr != nil {
+		return quotaErr
+	}
+	return nil
+}
+
+// QuotaFailure returns the QuotaError a reply workflow failed with, or nil if it did
+// not fail on a rate limit. A workflow's error read back from DBOS, such as that of
+// one fetched by ID, is only a message, so the output of its quota step is used.
+func QuotaFailure(ctx dbos.DBOSContext, workflowID string) (*QuotaError, error) {
+	steps, err := dbos.GetWorkflowSteps(ctx, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range steps {
+		if step.StepName != quotaStepName {
+			continue
+		}
+		output, ok := step.Output.(string)
+		if !ok {
+			return nil, nil
+		}
+		var quotaErr *QuotaError
+		if err := json.Unmarshal([]byte(output), &quotaErr); err != nil {
+			return nil, err
+		}
+		return quotaErr, nil
+	}
+	return nil, nil
+}
+
+// turnUsage lists the model calls behind a reply: those that produced its tool
+// calls and the one that produced it
+func turnUsage(conversationID uuid.UUID, reply completion, toolMsgs []models.Message) []ModelUsage {
+	var calls []ModelUsage
+	for _, msg := range toolMsgs {
+		if msg.Role != models.RoleToolCall {
+			continue
+		}
+		call := ModelUsage{ConversationID: &conversationID, Usage: msg.Usage}
+		if msg.Model != nil {
+			call.Model = *msg.Model
+		}
+		calls = append(calls, call)
+	}
+	return append(calls, callUsage(&conversationID, reply.ChatResult))
+}
@@ -0,0 +1,59 @@
+package workflows
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQuotaErrorRetryAfterSeconds(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter time.Duration
+		want       int
+	}{
+		{"whole seconds", 30 * time.Second, 30},
+		{"rounded up", 1500 * time.Millisecond, 2},
+		{"just over", 10*time.Second + time.Nanosecond, 11},
+		{"under a second", 200 * time.Millisecond, 1},
+		{"zero", 0, 1},
+		{"negative", -5 * time.Second, 1},
+		{"a day", 24 * time.Hour, 86400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := &QuotaError{Limit: "10 requests per minute for this user", RetryAfter: tt.retryAfter}
+			if got := err.RetryAfterSeconds(); got != tt.want {
+				t.Errorf("RetryAfterSeconds() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotaErrorStepOutput(t *testing.T) {
+	quotaErr := &QuotaError{Limit: "1000 tokens per day for this API key", RetryAfter: 90 * time.Second}
+	tests := []struct {
+		name string
+		in   *QuotaError
+	}{
+		{"quota error", quotaErr},
+		{"no quota error", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// DBOS stores step outputs as JSON
+			data, err := json.Marshal(tt.in)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			var got *QuotaError
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.in) {
+				t.Errorf("round trip = %+v, want %+v", got, tt.in)
+			}
+		})
+	}
+}
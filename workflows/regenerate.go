@@ -0,0 +1,84 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+
+	"chat-app/models"
+
+	"github.com/dbos-inc/dbos-transact-golang/dbos"
+	"github.com/google/uuid"
+)
+
+// RegenerateInput contains the input for the Regenerate workflow
+type RegenerateInput struct {
+	ConversationID uuid.UUID
+	// MessageID is the reply to regenerate, or the user message to answer again
+	MessageID uuid.UUID
+	// Model and Temperature override the conversation's settings for this reply only
+	Model       *string
+	Temperature *float64
+	// Caller is charged for the tokens the reply uses
+	Caller Caller
+}
+
+// RegenerateWorkflow asks the model again for the reply to a user message. The new
+// reply is saved as a sibling of the existing one and its branch becomes active.
+func (w *ChatWorkflows) RegenerateWorkflow(ctx dbos.DBOSContext, input RegenerateInput) (models.Message, error) {
+	// Step 1: Load the conversation's model settings (durable step)
+	conv, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (models.Conversation, error) {
+		return w.getConversation(stepCtx, input.ConversationID)
+	})
+	if err != nil {
+		return models.Message{}, err
+	}
+
+	// Refuse to regenerate if the caller is out of tokens for today (durable step)
+	if err := w.checkQuota(ctx, input.Caller); err != nil {
+		return models.Message{}, err
+	}
+
+	// Step 2: Get the branch up to the user message being answered (durable step)
+	branch, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) ([]models.Message, error) {
+		return w.branchToPrompt(stepCtx, input.ConversationID, input.MessageID)
+	})
+	if err != nil {
+		return models.Message{}, err
+	}
+	userMsg := branch[len(branch)-1]
+
+	// Steps 3-5: Resolve the system prompt, rolling summary and document excerpts (durable steps)
+	prompt, err := w.preparePrompt(ctx, conv, branch[:len(branch)-1], userMsg.Content)
+	if err != nil {
+		return models.Message{}, err
+	}
+
+	params := conv.ModelParams
+	if input.Model != nil && *input.Model != "" {
+		params.Model = input.Model
+	}
+	if input.Temperature != nil {
+		params.Temperature = input.Temperature
+	}
+
+	// Steps 6-9: Get the new AI response and save it (durable steps)
+	return w.reply(ctx, input.Caller, prompt, userMsg, params)
+}
+
+// branchToPrompt returns the branch from the root down to the user message that
+// prompted messageID (or messageID itself if it is a user message)
+func (w *ChatWorkflows) branchToPrompt(ctx context.Context, conversationID, messageID uuid.UUID) ([]models.Message, error) {
+	branch, err := w.getBranch(ctx, &messageID)
+	if err != nil {
+		return nil, err
+	}
+	if len(branch) == 0 || branch[0].ConversationID != conversationID {
+		return nil, fmt.Errorf("message %s not found", messageID)
+	}
+	for i := len(branch) - 1; i >= 0; i-- {
+		if branch[i].Role == models.RoleUser {
+			return branch[:i+1], nil
+		}
+	}
+	return nil, fmt.Errorf("message %s has no user message to answer", messageID)
+}
@@ -0,0 +1,42 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+
+	"chat-app/services"
+
+	"github.com/dbos-inc/dbos-transact-golang/dbos"
+)
+
+// modelStepResult is what a model step records. A failure the retry policy does
+// not retry is recorded as Err rather than returned, so DBOS does not retry it either.
+type modelStepResult[R any] struct {
+	Result R
+	Err    string
+}
+
+// runModelStep runs a model request as a durable step. Failures the policy deems
+// transient are retried with exponential backoff; a retried streaming turn sends
+// the listener a reset and then relays its reply again from the start.
+func runModelStep[R any](ctx dbos.DBOSContext, policy services.RetryPolicy, fn func(context.Context) (R, error)) (R, error) {
+	out, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (modelStepResult[R], error) {
+		result, err := fn(stepCtx)
+		if err != nil && !policy.Retryable(err) {
+			return modelStepResult[R]{Result: result, Err: err.Error()}, nil
+		}
+		return modelStepResult[R]{Result: result}, err
+	},
+		dbos.WithStepMaxRetries(policy.MaxAttempts-1),
+		dbos.WithBaseInterval(policy.BaseInterval),
+		dbos.WithMaxInterval(policy.MaxInterval),
+		dbos.WithBackoffFactor(policy.BackoffFactor),
+	)
+	if err != nil {
+		return out.Result, err
+	}
+	if out.Err != "" {
+		return out.Result, errors.New(out.Err)
+	}
+	return out.Result, nil
+}
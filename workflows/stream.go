@@ -0,0 +1,87 @@
+package workflows
+
+import "sync"
+
+// streamBufferSize is how many deltas may queue up before the producer waits for the listener
+const streamBufferSize = 256
+
+// StreamHub relays token deltas from running workflows to in-process listeners.
+// Deltas are best effort: a workflow recovered on another executor, or one
+// whose listener went away, simply completes without streaming.
+type StreamHub struct {
+	mu   sync.Mutex
+	subs map[string]*streamSub
+}
+
+// StreamEvent is what a listener receives: a token delta, or a reset telling it to
+// discard the deltas received so far because the reply is being generated again
+type StreamEvent struct {
+	Delta string
+	Reset bool
+}
+
+type streamSub struct {
+	ch   chan StreamEvent
+	done chan struct{}
+	// sent records whether deltas were sent since the last reset
+	sent bool
+}
+
+// NewStreamHub creates an empty stream hub
+func NewStreamHub() *StreamHub {
+	return &StreamHub{subs: make(map[string]*streamSub)}
+}
+
+// Subscribe registers a listener for the given workflow ID. It must be called
+// before the workflow starts. The returned function unsubscribes.
+func (h *StreamHub) Subscribe(workflowID string) (<-chan StreamEvent, func()) {
+	sub := &streamSub{
+		ch:   make(chan StreamEvent, streamBufferSize),
+		done: make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	h.subs[workflowID] = sub
+	h.mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			if h.subs[workflowID] == sub {
+				delete(h.subs, workflowID)
+			}
+			h.mu.Unlock()
+			close(sub.done)
+		})
+	}
+}
+
+// listener returns the listener for a workflow, or nil if nobody is listening
+func (h *StreamHub) listener(workflowID string) *streamSub {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.subs[workflowID]
+}
+
+// send delivers a delta, giving up if the listener unsubscribes
+func (s *streamSub) send(delta string) {
+	s.sent = true
+	s.deliver(StreamEvent{Delta: delta})
+}
+
+// reset tells the listener to discard the deltas sent so far, if there are any
+func (s *streamSub) reset() {
+	if !s.sent {
+		return
+	}
+	s.sent = false
+	s.deliver(StreamEvent{Reset: true})
+}
+
+func (s *streamSub) deliver(event StreamEvent) {
+	select {
+	case s.ch <- event:
+	case <-s.done:
+	}
+}
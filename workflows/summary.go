@@ -0,0 +1,187 @@
+package workflows
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"chat-app/models"
+	"chat-app/services"
+
+	"github.com/dbos-inc/dbos-transact-golang/dbos"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	summarySystemPrompt = `You maintain a running summary of a conversation between a user and an AI assistant.
+Write a concise summary that preserves the facts, decisions, names, numbers, user preferences
+and open questions needed to continue the conversation. Reply with the summary only.`
+	summaryMaxTokens   = 1024
+	summaryTemperature = 0.2
+)
+
+// summarizeOverflow folds the oldest history into a new rolling summary once the
+// history no longer fits the context window. req.System must already include the
+// current summary. It returns the summary to inject and the messages still sent verbatim.
+// The tokens the summary takes are charged to caller.
+func (w *ChatWorkflows) summarizeOverflow(ctx dbos.DBOSContext, caller Caller, conversationID uuid.UUID, req services.ChatRequest, summary *models.ConversationSummary) (*models.ConversationSummary, []models.Message, error) {
+	// Decide how much history to fold (durable step - the tokenizer may be remote)
+	cut, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (int, error) {
+		return w.contextBuilder.SummaryCut(stepCtx, req), nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if cut == 0 {
+		return summary, req.Messages, nil
+	}
+	folded := req.Messages[:cut]
+
+	// Ask the model for the updated summary (durable step, retried per the retry policy)
+	result, err := runModelStep(ctx, w.retry, func(stepCtx context.Context) (services.ChatResult, error) {
+		return w.generateSummary(stepCtx, req.Params, summary, folded)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Record the tokens used and charge them to the caller's daily quota (durable step)
+	if _, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (bool, error) {
+		return true, w.quotas.RecordTokens(stepCtx, caller, callUsage(&conversationID, result))
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	// Persist the summary (durable step)
+	next := models.ConversationSummary{
+		ConversationID: conversationID,
+		StartMessageID: folded[0].ID,
+		EndMessageID:   folded[len(folded)-1].ID,
+		MessageCount:   len(folded),
+		Content:        result.Content,
+	}
+	if summary != nil {
+		next.StartMessageID = summary.StartMessageID
+		next.MessageCount += summary.MessageCount
+	}
+	saved, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (models.ConversationSummary, error) {
+		return w.saveSummary(stepCtx, next)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &saved, req.Messages[cut:], nil
+}
+
+// generateSummary asks the provider to fold msgs into the previous summary
+func (w *ChatWorkflows) generateSummary(ctx context.Context, params models.ModelParams, prev *models.ConversationSummary, msgs []models.Message) (services.ChatResult, error) {
+	var prompt strings.Builder
+	if prev != nil {
+		prompt.WriteString("Summary so far:\n")
+		prompt.WriteString(prev.Content)
+		prompt.WriteString("\n\n")
+	}
+	prompt.WriteString("New messages:\n")
+	for _, msg := range msgs {
+		fmt.Fprintf(&prompt, "%s: %s\n", roleLabel(msg.Role), services.MessageText(msg))
+	}
+	prompt.WriteString("\nWrite the updated summary.")
+
+	maxTokens := summaryMaxTokens
+	temperature := summaryTemperature
+	return w.provider.Chat(ctx, services.ChatRequest{
+		System:      summarySystemPrompt,
+		UserMessage: prompt.String(),
+		Params: models.ModelParams{
+			Model:       params.Model,
+			MaxTokens:   &maxTokens,
+			Temperature: &temperature,
+		},
+	})
+}
+
+// latestSummary returns the newest summary of a conversation that ends on the given
+// branch, or nil if there is none. Summaries made on other branches do not apply.
+func (w *ChatWorkflows) latestSummary(ctx context.Context, conversationID uuid.UUID, branch []models.Message) (*models.ConversationSummary, error) {
+	if len(branch) == 0 {
+		return nil, nil
+	}
+	ids := make([]uuid.UUID, len(branch))
+	for i, msg := range branch {
+		ids[i] = msg.ID
+	}
+
+	var s models.ConversationSummary
+	err := w.db.QueryRowContext(ctx,
+		`SELECT id, conversation_id, start_message_id, end_message_id, message_count, content, created_at
+		 FROM conversation_summaries WHERE conversation_id = $1 AND end_message_id = ANY($2)
+		 ORDER BY created_at DESC LIMIT 1`,
+		conversationID, pq.Array(ids)).
+		Scan(&s.ID, &s.ConversationID, &s.StartMessageID, &s.EndMessageID, &s.MessageCount, &s.Content, &s.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// saveSummary saves a summary to the database, assigning its ID and timestamp
+func (w *ChatWorkflows) saveSummary(ctx context.Context, s models.ConversationSummary) (models.ConversationSummary, error) {
+	s.ID = uuid.New()
+	s.CreatedAt = time.Now()
+
+	_, err := w.db.ExecContext(ctx,
+		`INSERT INTO conversation_summaries (id, conversation_id, start_message_id, end_message_id, message_count, content, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		s.ID, s.ConversationID, s.StartMessageID, s.EndMessageID, s.MessageCount, s.Content, s.CreatedAt)
+	if err != nil {
+		return models.ConversationSummary{}, err
+	}
+	return s, nil
+}
+
+// unsummarized returns the messages that come after the summary's range.
+// If the summary's last message no longer exists the summary is dropped.
+func unsummarized(messages []models.Message, summary *models.ConversationSummary) ([]models.Message, *models.ConversationSummary) {
+	if summary == nil {
+		return messages, nil
+	}
+	for i, msg := range messages {
+		if msg.ID == summary.EndMessageID {
+			return messages[i+1:], summary
+		}
+	}
+	return messages, nil
+}
+
+// withSummary appends the summary of earlier messages to the system prompt
+func withSummary(system string, summary *models.ConversationSummary) string {
+	if summary == nil {
+		return system
+	}
+	section := "Summary of the earlier conversation:\n" + summary.Content
+	if system == "" {
+		return section
+	}
+	return system + "\n\n" + section
+}
+
+// roleLabel returns a transcript label for a message role
+func roleLabel(role string) string {
+	switch role {
+	case "user":
+		return "User"
+	case "assistant":
+		return "Assistant"
+	case models.RoleToolCall, models.RoleToolResult:
+		return "Tool"
+	default:
+		return role
+	}
+}
@@ -0,0 +1,100 @@
+package workflows
+
+import (
+	"context"
+	"strings"
+
+	"chat-app/models"
+	"chat-app/services"
+
+	"github.com/dbos-inc/dbos-transact-golang/dbos"
+	"github.com/google/uuid"
+)
+
+const (
+	titleSystemPrompt = `Write a short title (at most six words) for the conversation below.
+Reply with the title only: no quotes, no trailing punctuation.`
+	titleMaxTokens   = 24
+	titleMaxLength   = 80
+	titleTemperature = 0.3
+)
+
+// GenerateTitleInput contains the input for the GenerateTitle workflow
+type GenerateTitleInput struct {
+	ConversationID   uuid.UUID
+	Model            *string
+	UserMessage      string
+	AssistantMessage string
+	// Caller is charged for the tokens the title uses
+	Caller Caller
+}
+
+// GenerateTitleWorkflow asks the LLM for a short title after the first exchange.
+// A title set by the user in the meantime is never overwritten.
+func (w *ChatWorkflows) GenerateTitleWorkflow(ctx dbos.DBOSContext, input GenerateTitleInput) (string, error) {
+	// Step 1: Ask the model for a title (durable step, retried per the retry policy)
+	result, err := runModelStep(ctx, w.retry, func(stepCtx context.Context) (services.ChatResult, error) {
+		return w.generateTitle(stepCtx, input)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Step 2: Record the tokens used and charge them to the caller's daily quota (durable step)
+	if _, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (bool, error) {
+		return true, w.quotas.RecordTokens(stepCtx, input.Caller, callUsage(&input.ConversationID, result))
+	}); err != nil {
+		return "", err
+	}
+	title := result.Content
+	if title == "" {
+		return "", nil
+	}
+
+	// Step 3: Save it unless the conversation already has a title (durable step)
+	return dbos.RunAsStep(ctx, func(stepCtx context.Context) (string, error) {
+		_, err := w.db.ExecContext(stepCtx,
+			"UPDATE conversations SET title = $1 WHERE id = $2 AND title = ''",
+			title, input.ConversationID)
+		return title, err
+	})
+}
+
+// generateTitle asks the provider for a title and tidies up the reply, which it
+// returns as the result's Content
+func (w *ChatWorkflows) generateTitle(ctx context.Context, input GenerateTitleInput) (services.ChatResult, error) {
+	maxTokens := titleMaxTokens
+	temperature := titleTemperature
+	reply, err := w.provider.Chat(ctx, services.ChatRequest{
+		System:      titleSystemPrompt,
+		UserMessage: "User: " + input.UserMessage + "\n\nAssistant: " + input.AssistantMessage,
+		Params: models.ModelParams{
+			Model:       input.Model,
+			MaxTokens:   &maxTokens,
+			Temperature: &temperature,
+		},
+	})
+	if err != nil {
+		return services.ChatResult{}, err
+	}
+	reply.Content = cleanTitle(reply.Content)
+	return reply, nil
+}
+
+// cleanTitle keeps the first line of a model reply, strips quotes and
+// trailing punctuation, and caps its length
+func cleanTitle(reply string) string {
+	title := strings.TrimSpace(reply)
+	if i := strings.IndexByte(title, '\n'); i >= 0 {
+		title = title[:i]
+	}
+	title = strings.TrimPrefix(title, "Title:")
+	// Punctuation may sit inside or outside the quotes
+	title = strings.TrimRight(strings.TrimSpace(title), ".!?:; ")
+	title = strings.Trim(title, "\"'`*")
+	title = strings.TrimRight(title, ".!?:; ")
+	if runes := []rune(title); len(runes) > titleMaxLength {
+		title = strings.TrimSpace(string(runes[:titleMaxLength]))
+	}
+	return title
+}
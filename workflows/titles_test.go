@@ -0,0 +1,35 @@
+package workflows
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCleanTitle(t *testing.T) {
+	long := strings.Repeat("word ", 30)
+	tests := []struct {
+		name  string
+		reply string
+		want  string
+	}{
+		{"plain", "Planning a trip to Kyoto", "Planning a trip to Kyoto"},
+		{"surrounding whitespace", "  \n Go generics explained \n", "Go generics explained"},
+		{"first line only", "Sourdough basics\nHere is why I chose this title.", "Sourdough basics"},
+		{"title prefix", "Title: Debugging a race condition", "Debugging a race condition"},
+		{"double quotes", `"Tax filing questions"`, "Tax filing questions"},
+		{"single quotes", "'Tax filing questions'", "Tax filing questions"},
+		{"markdown bold", "**Rust borrow checker**", "Rust borrow checker"},
+		{"trailing punctuation", "What is a monad?!.", "What is a monad"},
+		{"quoted with period outside", `"Weekend plans".`, "Weekend plans"},
+		{"capped length", long, strings.TrimSpace(long[:titleMaxLength])},
+		{"multibyte capped on runes", strings.Repeat("é", 100), strings.Repeat("é", titleMaxLength)},
+		{"empty", "   ", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanTitle(tt.reply); got != tt.want {
+				t.Errorf("cleanTitle(%q) = %q, want %q", tt.reply, got, tt.want)
+			}
+		})
+	}
+}
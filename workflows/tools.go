@@ -0,0 +1,133 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+
+	"chat-app/models"
+	"chat-app/services"
+
+	"github.com/dbos-inc/dbos-transact-golang/dbos"
+	"github.com/google/uuid"
+)
+
+// maxToolRounds bounds how many times the model may call tools before it must answer
+const maxToolRounds = 5
+
+// offeredTools returns the tools to offer the model, or nil if the provider cannot
+// call tools or none are configured
+func (w *ChatWorkflows) offeredTools() []services.ToolSpec {
+	if _, ok := w.provider.(services.ToolCallingProvider); !ok {
+		return nil
+	}
+	return w.toolbox.Specs()
+}
+
+// respond produces the assistant reply to req. If req offers tools, it runs a loop:
+// each model turn is a durable step; the tool calls it makes are saved, each call is
+// executed in its own durable step, the results are saved, and the model is asked
+// again. It returns the reply and the tool call and result messages saved along the
+// way, which follow userMsg on its branch. A cancelled model turn ends the loop with
+// the text produced so far.
+func (w *ChatWorkflows) respond(ctx dbos.DBOSContext, workflowID string, userMsg models.Message, req services.ChatRequest) (completion, []models.Message, error) {
+	caller, ok := w.provider.(services.ToolCallingProvider)
+	if !ok || len(req.Tools) == 0 {
+		reply, err := runModelStep(ctx, w.retry, func(stepCtx context.Context) (completion, error) {
+			return w.complete(stepCtx, workflowID, req)
+		})
+		return reply, nil, err
+	}
+
+	parentID := userMsg.ID
+	for round := 0; ; round++ {
+		if round == maxToolRounds {
+			// Out of rounds: ask for a final answer, with this turn's tool messages as plain text
+			req.Tools = nil
+			reply, err := runModelStep(ctx, w.retry, func(stepCtx context.Context) (completion, error) {
+				return w.complete(stepCtx, workflowID, req)
+			})
+			return reply, req.Turn, err
+		}
+
+		// Model turn (durable step, retried per the retry policy)
+		result, err := runModelStep(ctx, w.retry, func(stepCtx context.Context) (completion, error) {
+			return w.completeWithTools(stepCtx, caller, workflowID, req)
+		})
+		if err != nil {
+			return completion{}, nil, err
+		}
+		if len(result.ToolCalls) == 0 || result.Cancelled {
+			return result, req.Turn, nil
+		}
+
+		// Save the tool calls (durable step)
+		callParent := parentID
+		callMsg, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (models.Message, error) {
+			return w.saveMessage(stepCtx, withTurn(models.Message{
+				ConversationID: userMsg.ConversationID,
+				Role:           models.RoleToolCall,
+				Content:        result.Content,
+				ToolCalls:      result.ToolCalls,
+				ParentID:       &callParent,
+			}, result.ChatResult))
+		})
+		if err != nil {
+			return completion{}, nil, err
+		}
+		req.Turn = append(req.Turn, callMsg)
+		parentID = callMsg.ID
+
+		for _, call := range result.ToolCalls {
+			// Run the tool (durable step); tool failures are reported to the model, not returned
+			output, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (string, error) {
+				return w.toolbox.Execute(stepCtx, call), nil
+			})
+			if err != nil {
+				return completion{}, nil, err
+			}
+
+			// Save the result (durable step)
+			callID, resultParent := call.ID, parentID
+			resultMsg, err := dbos.RunAsStep(ctx, func(stepCtx context.Context) (models.Message, error) {
+				return w.saveMessage(stepCtx, models.Message{
+					ConversationID: userMsg.ConversationID,
+					Role:           models.RoleToolResult,
+					Content:        output,
+					ToolCallID:     &callID,
+					ParentID:       &resultParent,
+				})
+			})
+			if err != nil {
+				return completion{}, nil, err
+			}
+			req.Turn = append(req.Turn, resultMsg)
+			parentID = resultMsg.ID
+		}
+	}
+}
+
+// completeWithTools runs one model turn with tools offered. Calls without an ID get
+// one so results can be matched to them. A final answer is relayed to a stream listener.
+func (w *ChatWorkflows) completeWithTools(ctx context.Context, caller services.ToolCallingProvider, workflowID string, req services.ChatRequest) (completion, error) {
+	ctx, done := w.generations.turnContext(ctx, workflowID)
+	defer done()
+
+	result, err := caller.ChatWithTools(ctx, req)
+	if err != nil {
+		return w.cancelled(workflowID, "", err)
+	}
+	for i := range result.ToolCalls {
+		if result.ToolCalls[i].ID == "" {
+			result.ToolCalls[i].ID = "call_" + uuid.NewString()
+		}
+	}
+	if len(result.ToolCalls) == 0 {
+		if result.Content == "" {
+			return completion{}, fmt.Errorf("empty response from %s", w.provider.Name())
+		}
+		if sub := w.streams.listener(workflowID); sub != nil {
+			sub.send(result.Content)
+		}
+	}
+	return completion{ChatResult: result}, nil
+}